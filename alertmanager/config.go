@@ -0,0 +1,321 @@
+package alertmanager
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"../prometheus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Catalogue declares the receivers alerts are routed to.
+// Receivers are matched in the order they are declared.
+type Catalogue struct {
+	Global       yaml.MapSlice        `yaml:"global"`
+	Templates    []string             `yaml:"templates"`
+	Route        CatalogueRoute       `yaml:"route"`
+	Receivers    []CatalogueReceiver  `yaml:"receivers"`
+	InhibitRules []CatalogueInhibitor `yaml:"inhibitRules"`
+}
+
+// CatalogueRoute defines the root route. Receiver gets all alerts that match no other receiver.
+type CatalogueRoute struct {
+	Receiver       string   `yaml:"receiver"`
+	GroupBy        []string `yaml:"groupBy"`
+	GroupWait      string   `yaml:"groupWait"`
+	GroupInterval  string   `yaml:"groupInterval"`
+	RepeatInterval string   `yaml:"repeatInterval"`
+}
+
+// CatalogueReceiver defines a receiver and the labels of the alerts routed to it.
+// When Expand is set, a receiver is created for each combination of the values of the Expand labels
+// found in alerts. Name and string values of Config can refer to those values, e.g. [[ .service ]].
+type CatalogueReceiver struct {
+	Name     string            `yaml:"name"`
+	Match    map[string]string `yaml:"match"`
+	MatchRe  map[string]string `yaml:"matchRe"`
+	Expand   []string          `yaml:"expand"`
+	Continue bool              `yaml:"continue"`
+	Config   yaml.MapSlice     `yaml:"config"`
+}
+
+// CatalogueInhibitor defines an inhibit rule
+type CatalogueInhibitor struct {
+	SourceMatch   map[string]string `yaml:"sourceMatch"`
+	SourceMatchRe map[string]string `yaml:"sourceMatchRe"`
+	TargetMatch   map[string]string `yaml:"targetMatch"`
+	TargetMatchRe map[string]string `yaml:"targetMatchRe"`
+	Equal         []string          `yaml:"equal"`
+}
+
+// Config is the Alertmanager configuration
+type Config struct {
+	Global       yaml.MapSlice   `yaml:"global,omitempty"`
+	Templates    []string        `yaml:"templates,omitempty"`
+	Route        *Route          `yaml:"route"`
+	Receivers    []yaml.MapSlice `yaml:"receivers"`
+	InhibitRules []*InhibitRule  `yaml:"inhibit_rules,omitempty"`
+}
+
+// Route is an Alertmanager route
+type Route struct {
+	Receiver       string            `yaml:"receiver"`
+	GroupBy        []string          `yaml:"group_by,omitempty"`
+	GroupWait      string            `yaml:"group_wait,omitempty"`
+	GroupInterval  string            `yaml:"group_interval,omitempty"`
+	RepeatInterval string            `yaml:"repeat_interval,omitempty"`
+	Match          map[string]string `yaml:"match,omitempty"`
+	MatchRe        map[string]string `yaml:"match_re,omitempty"`
+	Continue       bool              `yaml:"continue,omitempty"`
+	Routes         []*Route          `yaml:"routes,omitempty"`
+}
+
+// InhibitRule is an Alertmanager inhibit rule
+type InhibitRule struct {
+	SourceMatch   map[string]string `yaml:"source_match,omitempty"`
+	SourceMatchRe map[string]string `yaml:"source_match_re,omitempty"`
+	TargetMatch   map[string]string `yaml:"target_match,omitempty"`
+	TargetMatchRe map[string]string `yaml:"target_match_re,omitempty"`
+	Equal         []string          `yaml:"equal,omitempty"`
+}
+
+// UnroutedAlert is an alert that matches no receiver other than the one of the root route
+type UnroutedAlert struct {
+	Alert   string            `json:"alert"`
+	Service string            `json:"service"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// ParseCatalogue decodes and validates a receiver catalogue
+func ParseCatalogue(data []byte) (*Catalogue, error) {
+	c := &Catalogue{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if len(c.Route.Receiver) == 0 {
+		return nil, fmt.Errorf("route receiver is not defined")
+	}
+	defaultFound := false
+	for _, r := range c.Receivers {
+		if len(r.Name) == 0 {
+			return nil, fmt.Errorf("receiver name is not defined")
+		}
+		for label, re := range r.MatchRe {
+			if _, err := regexp.Compile("^(?:" + re + ")$"); err != nil {
+				return nil, fmt.Errorf("matchRe %s of the receiver %s is not valid: %s", label, r.Name, err.Error())
+			}
+		}
+		if _, err := newExpandTemplate(r.Name).Parse(r.Name); err != nil {
+			return nil, fmt.Errorf("receiver name %s is not a valid template: %s", r.Name, err.Error())
+		}
+		if r.Name == c.Route.Receiver && len(r.Expand) == 0 {
+			defaultFound = true
+		}
+	}
+	if !defaultFound {
+		return nil, fmt.Errorf("route receiver %s is not a receiver without expand", c.Route.Receiver)
+	}
+	return c, nil
+}
+
+// Generate creates the Alertmanager configuration for alerts.
+// It returns the alerts that are routed only to the root route receiver.
+func Generate(c *Catalogue, alerts map[string]prometheus.Alert) (*Config, []UnroutedAlert, error) {
+	config := &Config{
+		Global:    c.Global,
+		Templates: c.Templates,
+		Route: &Route{
+			Receiver:       c.Route.Receiver,
+			GroupBy:        c.Route.GroupBy,
+			GroupWait:      c.Route.GroupWait,
+			GroupInterval:  c.Route.GroupInterval,
+			RepeatInterval: c.Route.RepeatInterval,
+		},
+		Receivers: []yaml.MapSlice{},
+	}
+	labelSets := alertLabelSets(alerts)
+	names := map[string]bool{}
+	for _, r := range c.Receivers {
+		expansions := []map[string]string{{}}
+		if len(r.Expand) > 0 {
+			expansions = expandValues(r, labelSets)
+		}
+		for _, values := range expansions {
+			name, err := expand(r.Name, values)
+			if err != nil {
+				return nil, nil, err
+			}
+			if names[name] {
+				return nil, nil, fmt.Errorf("receiver %s is defined more than once", name)
+			}
+			names[name] = true
+			receiverConfig, err := expandValue(r.Config, values)
+			if err != nil {
+				return nil, nil, fmt.Errorf("receiver %s: %s", name, err.Error())
+			}
+			receiver := append(yaml.MapSlice{{Key: "name", Value: name}}, receiverConfig.(yaml.MapSlice)...)
+			config.Receivers = append(config.Receivers, receiver)
+			if name == c.Route.Receiver {
+				continue
+			}
+			route := &Route{Receiver: name, MatchRe: r.MatchRe, Continue: r.Continue}
+			if len(r.Match)+len(values) > 0 {
+				route.Match = map[string]string{}
+				for k, v := range r.Match {
+					route.Match[k] = v
+				}
+				for k, v := range values {
+					route.Match[k] = v
+				}
+			}
+			config.Route.Routes = append(config.Route.Routes, route)
+		}
+	}
+	for _, i := range c.InhibitRules {
+		config.InhibitRules = append(config.InhibitRules, &InhibitRule{
+			SourceMatch:   i.SourceMatch,
+			SourceMatchRe: i.SourceMatchRe,
+			TargetMatch:   i.TargetMatch,
+			TargetMatchRe: i.TargetMatchRe,
+			Equal:         i.Equal,
+		})
+	}
+	return config, unroutedAlerts(config.Route.Routes, alerts), nil
+}
+
+// alertLabelSets returns the labels of each alert, including the alertname label set by Prometheus
+// and the service label added to every rule
+func alertLabelSets(alerts map[string]prometheus.Alert) []map[string]string {
+	sets := []map[string]string{}
+	for _, a := range prometheus.SortedAlerts(alerts) {
+		sets = append(sets, alertLabels(a))
+	}
+	return sets
+}
+
+func alertLabels(a prometheus.Alert) map[string]string {
+	labels := prometheus.RuleLabels(a)
+	labels["alertname"] = a.AlertNameFormatted
+	return labels
+}
+
+// expandValues returns the distinct combinations of the values of the Expand labels
+// found in the label sets that match the receiver, ordered by their values
+func expandValues(r CatalogueReceiver, labelSets []map[string]string) []map[string]string {
+	found := map[string]map[string]string{}
+	keys := []string{}
+	for _, labels := range labelSets {
+		if !matches(labels, r.Match, r.MatchRe) {
+			continue
+		}
+		values := map[string]string{}
+		key := []string{}
+		for _, label := range r.Expand {
+			v, ok := labels[label]
+			if !ok {
+				break
+			}
+			values[label] = v
+			key = append(key, v)
+		}
+		if len(values) != len(r.Expand) {
+			continue
+		}
+		k := strings.Join(key, "\x00")
+		if _, ok := found[k]; !ok {
+			found[k] = values
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	expansions := []map[string]string{}
+	for _, k := range keys {
+		expansions = append(expansions, found[k])
+	}
+	return expansions
+}
+
+func unroutedAlerts(routes []*Route, alerts map[string]prometheus.Alert) []UnroutedAlert {
+	unrouted := []UnroutedAlert{}
+	for _, a := range prometheus.SortedAlerts(alerts) {
+		labels := alertLabels(a)
+		routed := false
+		for _, route := range routes {
+			if matches(labels, route.Match, route.MatchRe) {
+				routed = true
+				break
+			}
+		}
+		if !routed {
+			unrouted = append(unrouted, UnroutedAlert{Alert: a.AlertNameFormatted, Service: a.ServiceName, Labels: a.AlertLabels})
+		}
+	}
+	return unrouted
+}
+
+func matches(labels, match, matchRe map[string]string) bool {
+	for k, v := range match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	for k, re := range matchRe {
+		if ok, _ := regexp.MatchString("^(?:"+re+")$", labels[k]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// expandValue replaces references to expanded label values in all strings of value
+func expandValue(value interface{}, values map[string]string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expand(v, values)
+	case yaml.MapSlice:
+		expanded := yaml.MapSlice{}
+		for _, item := range v {
+			itemValue, err := expandValue(item.Value, values)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, yaml.MapItem{Key: item.Key, Value: itemValue})
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := []interface{}{}
+		for _, item := range v {
+			itemValue, err := expandValue(item, values)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, itemValue)
+		}
+		return expanded, nil
+	}
+	return value, nil
+}
+
+// expand renders s with values. [[ ]] delimiters are used so that Alertmanager templates are kept.
+func expand(s string, values map[string]string) (string, error) {
+	if !strings.Contains(s, "[[") {
+		return s, nil
+	}
+	tmpl, err := newExpandTemplate(s).Parse(s)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	if err := tmpl.Execute(b, values); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func newExpandTemplate(name string) *template.Template {
+	return template.New(name).Delims("[[", "]]").Option("missingkey=error")
+}
@@ -0,0 +1,196 @@
+package alertmanager
+
+import (
+	"testing"
+
+	"../prometheus"
+	"github.com/stretchr/testify/suite"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func TestConfigUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
+
+var catalogueYAML = `route:
+  receiver: slack
+  groupBy: [service, scale]
+  repeatInterval: 5m
+receivers:
+- name: slack
+  config:
+    slack_configs:
+    - send_resolved: true
+      title: "[{{ .Status | toUpper }}] {{ .GroupLabels.service }} service is in danger!"
+      api_url: https://hooks.slack.com/services/XXX
+- name: "jenkins-[[ .service ]]-[[ .scale ]]"
+  match:
+    type: service
+  matchRe:
+    scale: up|down
+  expand: [service, scale]
+  config:
+    webhook_configs:
+    - send_resolved: false
+      url: "http://jenkins/job/service-scale/buildWithParameters?service=[[ .service ]]&scale=[[ .scale ]]"
+- name: ops
+  match:
+    receiver: system
+inhibitRules:
+- sourceMatch:
+    scale: up
+  targetMatch:
+    receiver: system
+  equal: [service]
+`
+
+// ParseCatalogue
+
+func (s *ConfigTestSuite) Test_ParseCatalogue_ReturnsError_WhenCatalogueIsInvalid() {
+	testData := map[string]string{
+		"route receiver is not defined":                             "receivers:\n- name: slack\n",
+		"receiver name is not defined":                              "route:\n  receiver: slack\nreceivers:\n- match:\n    a: b\n",
+		"route receiver slack is not a receiver without expand":     "route:\n  receiver: slack\nreceivers:\n- name: ops\n",
+		"matchRe scale of the receiver slack is not valid":          "route:\n  receiver: slack\nreceivers:\n- name: slack\n  matchRe:\n    scale: \"(up\"\n",
+		"receiver name jenkins-[[ .service is not a valid template": "route:\n  receiver: slack\nreceivers:\n- name: slack\n- name: \"jenkins-[[ .service\"\n",
+	}
+
+	for expected, data := range testData {
+		_, err := ParseCatalogue([]byte(data))
+
+		s.Require().Error(err, expected)
+		s.Contains(err.Error(), expected)
+	}
+}
+
+// Generate
+
+func (s *ConfigTestSuite) Test_Generate_CreatesRoutesAndReceivers() {
+	catalogue, err := ParseCatalogue([]byte(catalogueYAML))
+	s.Require().NoError(err)
+	alerts := map[string]prometheus.Alert{
+		"go-demo_mainrespslow": {
+			ServiceName:        "go-demo_main",
+			AlertNameFormatted: "godemo_mainrespslow",
+			AlertLabels:        map[string]string{"receiver": "system", "service": "go-demo_main", "scale": "up", "type": "service"},
+		},
+		"go-demo_mainrespfast": {
+			ServiceName:        "go-demo_main",
+			AlertNameFormatted: "godemo_mainrespfast",
+			AlertLabels:        map[string]string{"receiver": "system", "service": "go-demo_main", "scale": "down", "type": "service"},
+		},
+		"go-demo_mainmemlimit": {
+			ServiceName:        "go-demo_main",
+			AlertNameFormatted: "godemo_mainmemlimit",
+			AlertLabels:        map[string]string{"receiver": "system", "service": "go-demo_main"},
+		},
+	}
+	expected := `route:
+  receiver: slack
+  group_by:
+  - service
+  - scale
+  repeat_interval: 5m
+  routes:
+  - receiver: jenkins-go-demo_main-down
+    match:
+      scale: down
+      service: go-demo_main
+      type: service
+    match_re:
+      scale: up|down
+  - receiver: jenkins-go-demo_main-up
+    match:
+      scale: up
+      service: go-demo_main
+      type: service
+    match_re:
+      scale: up|down
+  - receiver: ops
+    match:
+      receiver: system
+receivers:
+- name: slack
+  slack_configs:
+  - send_resolved: true
+    title: '[{{ .Status | toUpper }}] {{ .GroupLabels.service }} service is in danger!'
+    api_url: https://hooks.slack.com/services/XXX
+- name: jenkins-go-demo_main-down
+  webhook_configs:
+  - send_resolved: false
+    url: http://jenkins/job/service-scale/buildWithParameters?service=go-demo_main&scale=down
+- name: jenkins-go-demo_main-up
+  webhook_configs:
+  - send_resolved: false
+    url: http://jenkins/job/service-scale/buildWithParameters?service=go-demo_main&scale=up
+- name: ops
+inhibit_rules:
+- source_match:
+    scale: up
+  target_match:
+    receiver: system
+  equal:
+  - service
+`
+
+	config, unrouted, err := Generate(catalogue, alerts)
+	s.Require().NoError(err)
+	actual, _ := yaml.Marshal(config)
+
+	s.Equal(expected, string(actual))
+	s.Empty(unrouted)
+}
+
+func (s *ConfigTestSuite) Test_Generate_ReturnsUnroutedAlerts() {
+	catalogue, err := ParseCatalogue([]byte(catalogueYAML))
+	s.Require().NoError(err)
+	alerts := map[string]prometheus.Alert{
+		"go-demo_mainmemlimit": {
+			ServiceName:        "go-demo_main",
+			AlertNameFormatted: "godemo_mainmemlimit",
+			AlertLabels:        map[string]string{"receiver": "system", "service": "go-demo_main"},
+		},
+		"go-demo_mainerrors": {
+			ServiceName:        "go-demo_main",
+			AlertNameFormatted: "godemo_mainerrors",
+			AlertLabels:        map[string]string{"receiver": "team", "service": "go-demo_main"},
+		},
+		"go-demo_mainnolabels": {
+			ServiceName:        "go-demo_main",
+			AlertNameFormatted: "godemo_mainnolabels",
+		},
+	}
+
+	config, unrouted, err := Generate(catalogue, alerts)
+	s.Require().NoError(err)
+
+	s.Require().Len(config.Route.Routes, 1)
+	s.Equal("ops", config.Route.Routes[0].Receiver)
+	s.Equal([]UnroutedAlert{
+		{Alert: "godemo_mainerrors", Service: "go-demo_main", Labels: map[string]string{"receiver": "team", "service": "go-demo_main"}},
+		{Alert: "godemo_mainnolabels", Service: "go-demo_main"},
+	}, unrouted)
+}
+
+func (s *ConfigTestSuite) Test_Generate_ReturnsError_WhenReceiverNamesAreNotUnique() {
+	catalogue, err := ParseCatalogue([]byte(`route:
+  receiver: slack
+receivers:
+- name: slack
+- name: "team-[[ .team ]]"
+  expand: [team]
+- name: team-a
+`))
+	s.Require().NoError(err)
+	alerts := map[string]prometheus.Alert{
+		"myservicemyalert": {ServiceName: "my-service", AlertNameFormatted: "myservicemyalert", AlertLabels: map[string]string{"team": "a"}},
+	}
+
+	_, _, err = Generate(catalogue, alerts)
+
+	s.EqualError(err, "receiver team-a is defined more than once")
+}
@@ -0,0 +1,163 @@
+package alertmanager
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"../prometheus"
+	"github.com/spf13/afero"
+)
+
+var clientTimeout = 10 * time.Second
+
+// Silence is an Alertmanager silence as defined by the v2 API
+type Silence struct {
+	ID        string         `json:"id,omitempty"`
+	Matchers  []Matcher      `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	Status    *SilenceStatus `json:"status,omitempty"`
+}
+
+// Matcher selects the alerts muted by a silence
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+// SilenceStatus is the state of a silence: pending, active or expired
+type SilenceStatus struct {
+	State string `json:"state"`
+}
+
+// Client manages silences through the Alertmanager v2 API
+type Client struct {
+	URL         string
+	Username    string
+	Password    string
+	BearerToken string
+	HTTP        *http.Client
+}
+
+// NewClient creates a client from an ARG_ALERTMANAGER_URL entry.
+// The path prefix, the credentials, and the timeout, basicAuthPasswordSecret, bearerTokenSecret
+// and tlsInsecureSkipVerify options are used. Other options are ignored.
+func NewClient(alertURL string) (*Client, error) {
+	amURL, err := url.Parse(strings.TrimSpace(alertURL))
+	if err != nil {
+		return nil, err
+	}
+	if len(amURL.Host) == 0 {
+		return nil, fmt.Errorf("host is not defined")
+	}
+	query := amURL.Query()
+	c := &Client{
+		URL:  fmt.Sprintf("%s://%s%s", amURL.Scheme, amURL.Host, strings.TrimSuffix(amURL.Path, "/")),
+		HTTP: &http.Client{Timeout: clientTimeout},
+	}
+	if timeout := query.Get("timeout"); len(timeout) > 0 {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("timeout %s is not a duration", timeout)
+		}
+		c.HTTP.Timeout = d
+	}
+	if amURL.User != nil {
+		c.Username = amURL.User.Username()
+		c.Password, _ = amURL.User.Password()
+	}
+	if c.Password, err = readSecret(query.Get("basicAuthPasswordSecret"), c.Password); err != nil {
+		return nil, err
+	}
+	if c.BearerToken, err = readSecret(query.Get("bearerTokenSecret"), ""); err != nil {
+		return nil, err
+	}
+	if value := query.Get("tlsInsecureSkipVerify"); len(value) > 0 {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("tlsInsecureSkipVerify must be true or false")
+		}
+		c.HTTP.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}}
+	}
+	return c, nil
+}
+
+// readSecret returns the content of the secret name in prometheus.SecretsDir or value when name is empty
+func readSecret(name, value string) (string, error) {
+	if len(name) == 0 {
+		return value, nil
+	}
+	data, err := afero.ReadFile(prometheus.FS, filepath.Join(prometheus.SecretsDir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Silences returns all silences, including the expired ones Alertmanager still keeps
+func (c *Client) Silences() ([]Silence, error) {
+	silences := []Silence{}
+	if err := c.do(http.MethodGet, "/api/v2/silences", nil, &silences); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+// PostSilence creates silence or, when its ID is set, updates it.
+// It returns the ID Alertmanager assigned to the silence, which can differ from the ID of an updated silence.
+func (c *Client) PostSilence(silence Silence) (string, error) {
+	silence.Status = nil
+	body, _ := json.Marshal(silence)
+	resp := struct {
+		SilenceID string `json:"silenceID"`
+	}{}
+	if err := c.do(http.MethodPost, "/api/v2/silences", body, &resp); err != nil {
+		return "", err
+	}
+	return resp.SilenceID, nil
+}
+
+// ExpireSilence ends the silence with the id
+func (c *Client) ExpireSilence(id string) error {
+	return c.do(http.MethodDelete, "/api/v2/silence/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) do(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.Username) > 0 {
+		req.SetBasicAuth(c.Username, c.Password)
+	} else if len(c.BearerToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alertmanager responded to %s %s with %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
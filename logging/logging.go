@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level defines the severity of a log entry
+type Level int32
+
+// Levels in increasing order of severity
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return fmt.Sprintf("level(%d)", l)
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return WarnLevel, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("Unknown log level %s. Use one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// Fields are key/value pairs attached to a log entry.
+// Use the keys service, alert and node when an entry refers to them.
+type Fields map[string]interface{}
+
+// Logger writes leveled entries in logfmt or JSON format
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	json  bool
+	level int32
+	now   func() time.Time
+}
+
+// Default is the logger shared by all packages.
+// It is configured through DF_LOG_FORMAT (logfmt or json) and DF_LOG_LEVEL.
+var Default = NewFromEnv(os.Stderr)
+
+// New returns a logger that writes entries with level or higher to out.
+// Format can be logfmt or json.
+func New(out io.Writer, format string, level Level) *Logger {
+	return &Logger{
+		out:   out,
+		json:  strings.ToLower(format) == "json",
+		level: int32(level),
+		now:   time.Now,
+	}
+}
+
+// NewFromEnv returns a logger configured through DF_LOG_FORMAT and DF_LOG_LEVEL
+func NewFromEnv(out io.Writer) *Logger {
+	level, err := ParseLevel(os.Getenv("DF_LOG_LEVEL"))
+	if len(os.Getenv("DF_LOG_LEVEL")) == 0 || err != nil {
+		level = InfoLevel
+	}
+	return New(out, os.Getenv("DF_LOG_FORMAT"), level)
+}
+
+// SetLevel changes the minimum level of entries that are written
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// Level returns the minimum level of entries that are written
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+// Enabled returns true when entries with level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Debug writes an entry with the debug level
+func (l *Logger) Debug(msg string, fields Fields) {
+	l.Log(DebugLevel, msg, fields)
+}
+
+// Info writes an entry with the info level
+func (l *Logger) Info(msg string, fields Fields) {
+	l.Log(InfoLevel, msg, fields)
+}
+
+// Warn writes an entry with the warn level
+func (l *Logger) Warn(msg string, fields Fields) {
+	l.Log(WarnLevel, msg, fields)
+}
+
+// Error writes an entry with the error level
+func (l *Logger) Error(msg string, fields Fields) {
+	l.Log(ErrorLevel, msg, fields)
+}
+
+// Log writes an entry when level is enabled
+func (l *Logger) Log(level Level, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	var line []byte
+	if l.json {
+		line = l.formatJSON(level, msg, fields)
+	} else {
+		line = l.formatLogfmt(level, msg, fields)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+func (l *Logger) formatJSON(level Level, msg string, fields Fields) []byte {
+	entry := map[string]interface{}{}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{
+			"time":  l.now().UTC().Format(time.RFC3339),
+			"level": level.String(),
+			"msg":   msg,
+			"error": err.Error(),
+		})
+	}
+	return append(line, '\n')
+}
+
+func (l *Logger) formatLogfmt(level Level, msg string, fields Fields) []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "time=%s level=%s msg=%s", l.now().UTC().Format(time.RFC3339), level, logfmtValue(msg))
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, " %s=%s", k, logfmtValue(fmt.Sprint(fields[k])))
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func logfmtValue(value string) string {
+	if len(value) == 0 || strings.ContainsAny(value, " =\"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+}
+
+func TestLoggingUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
+
+// Log
+
+func (s *LoggingTestSuite) Test_Log_WritesLogfmt() {
+	out := &bytes.Buffer{}
+	l := s.newLogger(out, "logfmt", InfoLevel)
+
+	l.Info("Adding alert", Fields{"service": "my-service", "alert": "myservice_mem", "expr": "a > b"})
+
+	s.Equal("time=2018-05-01T10:00:00Z level=info msg=\"Adding alert\" alert=myservice_mem expr=\"a > b\" service=my-service\n", out.String())
+}
+
+func (s *LoggingTestSuite) Test_Log_WritesJSON() {
+	out := &bytes.Buffer{}
+	l := s.newLogger(out, "json", InfoLevel)
+
+	l.Error("Prometheus reload failed", Fields{"node": "node-1", "error": errors.New("This is an error")})
+
+	actual := map[string]string{}
+	s.Require().NoError(json.Unmarshal(out.Bytes(), &actual))
+	s.Equal(map[string]string{
+		"time":  "2018-05-01T10:00:00Z",
+		"level": "error",
+		"msg":   "Prometheus reload failed",
+		"node":  "node-1",
+		"error": "This is an error",
+	}, actual)
+}
+
+func (s *LoggingTestSuite) Test_Log_SkipsEntriesBelowLevel() {
+	out := &bytes.Buffer{}
+	l := s.newLogger(out, "logfmt", WarnLevel)
+
+	l.Debug("debug", nil)
+	l.Info("info", nil)
+	s.Empty(out.String())
+
+	l.SetLevel(DebugLevel)
+	l.Debug("debug", nil)
+	s.Equal("time=2018-05-01T10:00:00Z level=debug msg=debug\n", out.String())
+}
+
+// ParseLevel
+
+func (s *LoggingTestSuite) Test_ParseLevel_ReturnsLevel() {
+	for name, expected := range map[string]Level{
+		"debug":   DebugLevel,
+		"INFO":    InfoLevel,
+		"warn":    WarnLevel,
+		"warning": WarnLevel,
+		"error":   ErrorLevel,
+	} {
+		actual, err := ParseLevel(name)
+		s.NoError(err)
+		s.Equal(expected, actual)
+	}
+}
+
+func (s *LoggingTestSuite) Test_ParseLevel_ReturnsError_WhenLevelIsUnknown() {
+	_, err := ParseLevel("verbose")
+
+	s.Error(err)
+}
+
+// NewFromEnv
+
+func (s *LoggingTestSuite) Test_NewFromEnv_UsesFormatAndLevelFromEnv() {
+	defer func() {
+		os.Unsetenv("DF_LOG_FORMAT")
+		os.Unsetenv("DF_LOG_LEVEL")
+	}()
+	os.Setenv("DF_LOG_FORMAT", "json")
+	os.Setenv("DF_LOG_LEVEL", "debug")
+
+	l := NewFromEnv(&bytes.Buffer{})
+
+	s.True(l.json)
+	s.Equal(DebugLevel, l.Level())
+}
+
+func (s *LoggingTestSuite) Test_NewFromEnv_DefaultsToLogfmtAndInfo() {
+	l := NewFromEnv(&bytes.Buffer{})
+
+	s.False(l.json)
+	s.Equal(InfoLevel, l.Level())
+}
+
+// Util
+
+func (s *LoggingTestSuite) newLogger(out *bytes.Buffer, format string, level Level) *Logger {
+	l := New(out, format, level)
+	l.now = func() time.Time {
+		return time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	}
+	return l
+}
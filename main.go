@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"./server"
 )
 
@@ -13,5 +15,7 @@ import (
 // TODO: Alert labels
 // TODO: Alert annotations
 func main() {
-	server.New().Execute()
+	if err := server.New().Execute(); err != nil {
+		os.Exit(1)
+	}
 }
@@ -2,23 +2,30 @@ package prometheus
 
 import (
 	"bytes"
+	"sort"
 	"text/template"
 )
 
 // GetAlertConfig returns Prometheus configuration snippet related to alerts.
+// Rules are ordered by AlertNameFormatted so that the output is stable between writes.
 func GetAlertConfig(alerts map[string]Alert) string {
+	return getAlertGroupConfig("alert.rules", alerts)
+}
+
+// getAlertGroupConfig returns a rule file with a single group that contains alerts
+func getAlertGroupConfig(group string, alerts map[string]Alert) string {
 	templateString := `groups:
-- name: alert.rules
+- name: {{ .Name }}
   rules:
-  {{- range . }}
+  {{- range .Alerts }}
   - alert: {{ .AlertNameFormatted }}
     expr: {{ .AlertIf }}
     {{- if .AlertFor }}
     for: {{ .AlertFor }}
     {{- end }}
-    {{- if .AlertLabels }}
+    {{- with ruleLabels . }}
     labels:
-    {{- range $key, $value := .AlertLabels }}
+    {{- range $key, $value := . }}
       {{ $key }}: {{ $value }}
     {{- end}}
     {{- end }}
@@ -29,8 +36,37 @@ func GetAlertConfig(alerts map[string]Alert) string {
     {{- end}}
     {{- end }}
   {{- end }}`
-	tmpl, _ := template.New("").Parse(templateString)
+	tmpl, _ := template.New("").Funcs(template.FuncMap{"ruleLabels": RuleLabels}).Parse(templateString)
 	var b bytes.Buffer
-	tmpl.Execute(&b, alerts)
+	tmpl.Execute(&b, struct {
+		Name   string
+		Alerts []Alert
+	}{group, SortedAlerts(alerts)})
 	return b.String()
 }
+
+// RuleLabels returns the labels of the rule of alert. Every rule gets the service label,
+// so that the alerts of a service can be matched, e.g. by maintenance windows,
+// even when their expressions do not keep it.
+func RuleLabels(alert Alert) map[string]string {
+	labels := map[string]string{}
+	if len(alert.ServiceName) > 0 {
+		labels["service"] = alert.ServiceName
+	}
+	for k, v := range alert.AlertLabels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// SortedAlerts returns the alerts ordered by their formatted name
+func SortedAlerts(alerts map[string]Alert) []Alert {
+	sorted := make([]Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		sorted = append(sorted, alert)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].AlertNameFormatted < sorted[j].AlertNameFormatted
+	})
+	return sorted
+}
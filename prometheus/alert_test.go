@@ -2,8 +2,10 @@ package prometheus
 
 import (
 	"fmt"
+	"io/ioutil"
 	"testing"
 
+	"../logging"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -16,9 +18,9 @@ func (s *AlertTestSuite) SetupTest() {
 
 func TestAlertUnitTestSuite(t *testing.T) {
 	s := new(AlertTestSuite)
-	logPrintlnOrig := logPrintf
-	defer func() { logPrintf = logPrintlnOrig }()
-	logPrintf = func(format string, v ...interface{}) {}
+	loggerOrig := logger
+	defer func() { logger = loggerOrig }()
+	logger = logging.New(ioutil.Discard, "", logging.InfoLevel)
 	suite.Run(t, s)
 }
 
@@ -33,7 +35,9 @@ func (s *AlertTestSuite) Test_GetAlertConfig_ReturnsConfigWithData() {
 		expected += fmt.Sprintf(`
   - alert: alertNameFormatted%d
     expr: alert-if-%d
-    for: alert-for-%d`, i, i, i)
+    for: alert-for-%d
+    labels:
+      service: my-service-%d`, i, i, i, i)
 	}
 
 	actual := GetAlertConfig(alerts)
@@ -53,8 +57,9 @@ func (s *AlertTestSuite) Test_GetAlertConfig_ReturnsConfigWithLabels_WhenPresent
     for: alert-for-%d
     labels:
       alert-label-%d-1: alert-label-value-%d-1
-      alert-label-%d-2: alert-label-value-%d-2`,
-			i, i, i, i, i, i, i)
+      alert-label-%d-2: alert-label-value-%d-2
+      service: my-service-%d`,
+			i, i, i, i, i, i, i, i)
 		key := fmt.Sprintf("alert-name-%d", i)
 		alert := alerts[key]
 		alert.AlertLabels = map[string]string{
@@ -79,10 +84,12 @@ func (s *AlertTestSuite) Test_GetAlertConfig_ReturnsConfigWithAnnotations_WhenPr
   - alert: alertNameFormatted%d
     expr: alert-if-%d
     for: alert-for-%d
+    labels:
+      service: my-service-%d
     annotations:
       alert-annotation-%d-1: "alert-annotation-value-%d-1"
       alert-annotation-%d-2: "alert-annotation-value-%d-2"`,
-			i, i, i, i, i, i, i)
+			i, i, i, i, i, i, i, i)
 		key := fmt.Sprintf("alert-name-%d", i)
 		alert := alerts[key]
 		alert.AlertAnnotations = map[string]string{
@@ -97,6 +104,47 @@ func (s *AlertTestSuite) Test_GetAlertConfig_ReturnsConfigWithAnnotations_WhenPr
 	s.Equal(expected, actual)
 }
 
+func (s *AlertTestSuite) Test_GetAlertConfig_KeepsServiceLabel_WhenAlertDefinesIt() {
+	expected := `groups:
+- name: alert.rules
+  rules:
+  - alert: myservice_alert
+    expr: a-if
+    labels:
+      service: other-service`
+	alerts := map[string]Alert{
+		"myservice_alert": {
+			AlertNameFormatted: "myservice_alert",
+			ServiceName:        "my-service",
+			AlertIf:            "a-if",
+			AlertLabels:        map[string]string{"service": "other-service"},
+		},
+	}
+
+	s.Equal(expected, GetAlertConfig(alerts))
+}
+
+func (s *AlertTestSuite) Test_GetAlertConfig_OrdersRulesByAlertNameFormatted() {
+	expected := `groups:
+- name: alert.rules
+  rules:
+  - alert: a_alert
+    expr: a-if
+  - alert: b_alert
+    expr: b-if
+  - alert: c_alert
+    expr: c-if`
+	alerts := map[string]Alert{
+		"x": {AlertNameFormatted: "c_alert", AlertIf: "c-if"},
+		"y": {AlertNameFormatted: "a_alert", AlertIf: "a-if"},
+		"z": {AlertNameFormatted: "b_alert", AlertIf: "b-if"},
+	}
+
+	for i := 0; i < 10; i++ {
+		s.Equal(expected, GetAlertConfig(alerts))
+	}
+}
+
 // Util
 
 func (s *AlertTestSuite) getTestAlerts() map[string]Alert {
@@ -0,0 +1,256 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var apiTimeout = 10 * time.Second
+
+// ErrNotInstantVector is returned by Query for expressions that cannot be used as alert conditions
+var ErrNotInstantVector = errors.New("the expression does not return an instant vector")
+
+// APIClient queries the HTTP API of Prometheus
+type APIClient struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// RuleGroup is a rule group returned by the rules API
+type RuleGroup struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is a rule returned by the rules API. Type is either alerting or recording.
+type Rule struct {
+	Name           string        `json:"name"`
+	Query          string        `json:"query"`
+	Type           string        `json:"type"`
+	State          string        `json:"state"`
+	Health         string        `json:"health"`
+	LastError      string        `json:"lastError"`
+	LastEvaluation time.Time     `json:"lastEvaluation"`
+	Alerts         []ActiveAlert `json:"alerts"`
+}
+
+// ActiveAlert is a pending or firing alert
+type ActiveAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	State       string            `json:"state"`
+	ActiveAt    *time.Time        `json:"activeAt,omitempty"`
+	Value       string            `json:"value"`
+}
+
+// Target is an active target returned by the targets API. Health is up, down or unknown.
+type Target struct {
+	DiscoveredLabels   map[string]string `json:"discoveredLabels"`
+	Labels             map[string]string `json:"labels"`
+	ScrapePool         string            `json:"scrapePool"`
+	ScrapeURL          string            `json:"scrapeUrl"`
+	LastError          string            `json:"lastError"`
+	LastScrape         time.Time         `json:"lastScrape"`
+	LastScrapeDuration float64           `json:"lastScrapeDuration"`
+	Health             string            `json:"health"`
+}
+
+// SamplePair is a value of a series at a time
+type SamplePair struct {
+	Time  time.Time
+	Value string
+}
+
+// UnmarshalJSON decodes the [unix time, "value"] format of the API
+func (p *SamplePair) UnmarshalJSON(data []byte) error {
+	pair := []interface{}{}
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("%s is not a sample", string(data))
+	}
+	t, ok := pair[0].(float64)
+	value, ok2 := pair[1].(string)
+	if !ok || !ok2 {
+		return fmt.Errorf("%s is not a sample", string(data))
+	}
+	p.Time = time.Unix(0, int64(t*1000+0.5)*int64(time.Millisecond)).UTC()
+	p.Value = value
+	return nil
+}
+
+// MarshalJSON encodes the pair in the same format as the API
+func (p SamplePair) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{float64(p.Time.UnixNano()/int64(time.Millisecond)) / 1000, p.Value})
+}
+
+// Sample is a series returned by an instant query
+type Sample struct {
+	Metric map[string]string `json:"metric"`
+	Value  SamplePair        `json:"value"`
+}
+
+// Series is a series returned by a range query
+type Series struct {
+	Metric map[string]string `json:"metric"`
+	Values []SamplePair      `json:"values"`
+}
+
+// APIError is an error returned by Prometheus, e.g. bad_data for an invalid query
+type APIError struct {
+	Path    string
+	Type    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("prometheus responded to %s with %s: %s", e.Path, e.Type, e.Message)
+}
+
+// apiResponse is the envelope of all API responses
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// NewAPIClient creates a client of the Prometheus started by Run.
+// DF_PROMETHEUS_URL overrides the address derived from ARG_WEB_LISTEN-ADDRESS and ARG_WEB_ROUTE-PREFIX.
+func NewAPIClient() *APIClient {
+	return &APIClient{URL: apiURL(), HTTP: &http.Client{Timeout: apiTimeout}}
+}
+
+func apiURL() string {
+	if u := os.Getenv("DF_PROMETHEUS_URL"); len(u) > 0 {
+		return strings.TrimSuffix(u, "/")
+	}
+	port := "9090"
+	if _, p, err := net.SplitHostPort(argValue("web.listen-address")); err == nil && len(p) > 0 {
+		port = p
+	}
+	prefix := strings.Trim(argValue("web.route-prefix"), "/")
+	if len(prefix) > 0 {
+		prefix = "/" + prefix
+	}
+	return fmt.Sprintf("http://localhost:%s%s", port, prefix)
+}
+
+// argValue returns the value of the Prometheus flag defined through an ARG environment variable
+func argValue(flag string) string {
+	for _, e := range os.Environ() {
+		if key, value := getArgFromEnv(e, "ARG"); strings.Replace(key, "_", ".", -1) == flag {
+			return value
+		}
+	}
+	return ""
+}
+
+// Rules returns the rule groups loaded by Prometheus
+func (c *APIClient) Rules() ([]RuleGroup, error) {
+	data := struct {
+		Groups []RuleGroup `json:"groups"`
+	}{}
+	if err := c.get("/api/v1/rules", nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Groups, nil
+}
+
+// Alerts returns the pending and firing alerts
+func (c *APIClient) Alerts() ([]ActiveAlert, error) {
+	data := struct {
+		Alerts []ActiveAlert `json:"alerts"`
+	}{}
+	if err := c.get("/api/v1/alerts", nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Alerts, nil
+}
+
+// Targets returns the active targets
+func (c *APIClient) Targets() ([]Target, error) {
+	query := url.Values{}
+	query.Set("state", "active")
+	data := struct {
+		ActiveTargets []Target `json:"activeTargets"`
+	}{}
+	if err := c.get("/api/v1/targets", query, &data); err != nil {
+		return nil, err
+	}
+	return data.ActiveTargets, nil
+}
+
+// Query evaluates expr at time t. Expressions that do not return an instant vector are rejected.
+func (c *APIClient) Query(expr string, t time.Time) ([]Sample, error) {
+	query := url.Values{}
+	query.Set("query", expr)
+	query.Set("time", formatAPITime(t))
+	data := struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	}{}
+	if err := c.get("/api/v1/query", query, &data); err != nil {
+		return nil, err
+	}
+	if data.ResultType != "vector" {
+		return nil, ErrNotInstantVector
+	}
+	samples := []Sample{}
+	if err := json.Unmarshal(data.Result, &samples); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// QueryRange evaluates expr from start to end in steps
+func (c *APIClient) QueryRange(expr string, start, end time.Time, step time.Duration) ([]Series, error) {
+	query := url.Values{}
+	query.Set("query", expr)
+	query.Set("start", formatAPITime(start))
+	query.Set("end", formatAPITime(end))
+	query.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	data := struct {
+		Result []Series `json:"result"`
+	}{}
+	if err := c.get("/api/v1/query_range", query, &data); err != nil {
+		return nil, err
+	}
+	return data.Result, nil
+}
+
+func formatAPITime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano()/int64(time.Millisecond))/1000, 'f', -1, 64)
+}
+
+func (c *APIClient) get(path string, query url.Values, data interface{}) error {
+	u := c.URL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := c.HTTP.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	apiResp := apiResponse{}
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return fmt.Errorf("prometheus responded to %s with %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if apiResp.Status != "success" {
+		return &APIError{Path: path, Type: apiResp.ErrorType, Message: apiResp.Error}
+	}
+	return json.Unmarshal(apiResp.Data, data)
+}
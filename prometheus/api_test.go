@@ -0,0 +1,147 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type APITestSuite struct {
+	suite.Suite
+}
+
+func TestAPIUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(APITestSuite))
+}
+
+// NewAPIClient
+
+func (s *APITestSuite) Test_NewAPIClient_UsesListenAddressAndRoutePrefix() {
+	defer os.Unsetenv("ARG_WEB_LISTEN-ADDRESS")
+	defer os.Unsetenv("ARG_WEB_ROUTE-PREFIX")
+
+	s.Equal("http://localhost:9090", NewAPIClient().URL)
+
+	os.Setenv("ARG_WEB_LISTEN-ADDRESS", "0.0.0.0:9091")
+	os.Setenv("ARG_WEB_ROUTE-PREFIX", "/monitor/")
+
+	s.Equal("http://localhost:9091/monitor", NewAPIClient().URL)
+}
+
+func (s *APITestSuite) Test_NewAPIClient_UsesPrometheusURL_WhenDefined() {
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", "http://prometheus:9090/")
+
+	s.Equal("http://prometheus:9090", NewAPIClient().URL)
+}
+
+// Rules
+
+func (s *APITestSuite) Test_Rules_ReturnsRuleGroups() {
+	actualPath := ""
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actualPath = r.URL.Path
+		w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"alert.rules","file":"/etc/prometheus/alert.rules","rules":[
+			{"name":"myservicemem","query":"a > b","type":"alerting","state":"firing","health":"ok","lastError":"",
+			 "alerts":[{"labels":{"alertname":"myservicemem"},"state":"firing","activeAt":"2021-03-01T10:00:00Z","value":"1e+00"}]}]}]}}`))
+	}))
+	defer testServer.Close()
+	client := &APIClient{URL: testServer.URL, HTTP: http.DefaultClient}
+
+	groups, err := client.Rules()
+
+	s.Require().NoError(err)
+	s.Equal("/api/v1/rules", actualPath)
+	s.Require().Len(groups, 1)
+	s.Require().Len(groups[0].Rules, 1)
+	rule := groups[0].Rules[0]
+	s.Equal("myservicemem", rule.Name)
+	s.Equal("firing", rule.State)
+	s.Require().Len(rule.Alerts, 1)
+	s.Equal("1e+00", rule.Alerts[0].Value)
+}
+
+func (s *APITestSuite) Test_Rules_ReturnsError_WhenRequestFails() {
+	testData := map[string]string{
+		`{"status":"error","errorType":"bad_data","error":"invalid parameter"}`: "prometheus responded to /api/v1/rules with bad_data: invalid parameter",
+		"404 page not found": "prometheus responded to /api/v1/rules with 404: 404 page not found",
+	}
+
+	for body, expected := range testData {
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(body))
+		}))
+		client := &APIClient{URL: testServer.URL, HTTP: http.DefaultClient}
+
+		_, err := client.Rules()
+
+		s.EqualError(err, expected)
+		testServer.Close()
+	}
+}
+
+// Query
+
+func (s *APITestSuite) Test_Query_ReturnsSamples() {
+	actualQuery := ""
+	actualTime := ""
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actualQuery = r.URL.Query().Get("query")
+		actualTime = r.URL.Query().Get("time")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"instance":"a"},"value":[1614592800.5,"0.9"]}]}}`))
+	}))
+	defer testServer.Close()
+	client := &APIClient{URL: testServer.URL, HTTP: http.DefaultClient}
+
+	samples, err := client.Query("up == 0", time.Unix(1614592800, 500000000))
+
+	s.Require().NoError(err)
+	s.Equal("up == 0", actualQuery)
+	s.Equal("1614592800.5", actualTime)
+	s.Equal([]Sample{{
+		Metric: map[string]string{"instance": "a"},
+		Value:  SamplePair{Time: time.Unix(1614592800, 500000000).UTC(), Value: "0.9"},
+	}}, samples)
+}
+
+func (s *APITestSuite) Test_Query_ReturnsError_WhenResultIsNotInstantVector() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1614592800,"1"]}}`))
+	}))
+	defer testServer.Close()
+	client := &APIClient{URL: testServer.URL, HTTP: http.DefaultClient}
+
+	_, err := client.Query("1", time.Now())
+
+	s.Equal(ErrNotInstantVector, err)
+}
+
+// QueryRange
+
+func (s *APITestSuite) Test_QueryRange_ReturnsSeries() {
+	actualQuery := url.Values{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actualQuery = r.URL.Query()
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"instance":"a"},"values":[[1614592800,"1"],[1614592860,"2"]]}]}}`))
+	}))
+	defer testServer.Close()
+	client := &APIClient{URL: testServer.URL, HTTP: http.DefaultClient}
+
+	series, err := client.QueryRange("up == 0", time.Unix(1614589200, 0), time.Unix(1614592800, 0), 30*time.Second)
+
+	s.Require().NoError(err)
+	s.Equal("1614589200", actualQuery.Get("start"))
+	s.Equal("1614592800", actualQuery.Get("end"))
+	s.Equal("30", actualQuery.Get("step"))
+	s.Require().Len(series, 1)
+	s.Equal([]SamplePair{
+		{Time: time.Unix(1614592800, 0).UTC(), Value: "1"},
+		{Time: time.Unix(1614592860, 0).UTC(), Value: "2"},
+	}, series[0].Values)
+}
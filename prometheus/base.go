@@ -0,0 +1,415 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"../logging"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigConflict describes a generated setting that conflicts with the base configuration.
+// The value from the base configuration is always kept.
+type ConfigConflict struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// mergeListKeys defines the field that identifies items of lists that cannot contain duplicates
+var mergeListKeys = map[string]string{
+	"scrape_configs": "job_name",
+	"remote_write":   "url",
+	"remote_read":    "url",
+}
+
+var conflictsMu sync.Mutex
+var baseConfigConflicts = []ConfigConflict{}
+
+// BaseConfigConflicts returns the conflicts found the last time WriteConfig merged
+// the base configuration defined through DF_BASE_CONFIG
+func BaseConfigConflicts() []ConfigConflict {
+	conflictsMu.Lock()
+	defer conflictsMu.Unlock()
+	return append([]ConfigConflict{}, baseConfigConflicts...)
+}
+
+func setBaseConfigConflicts(conflicts []ConfigConflict) {
+	conflictsMu.Lock()
+	defer conflictsMu.Unlock()
+	if reflect.DeepEqual(conflicts, baseConfigConflicts) {
+		return
+	}
+	for _, c := range conflicts {
+		logger.Warn("Generated config conflicts with the base config", logging.Fields{"path": c.Path, "conflict": c.Message})
+	}
+	baseConfigConflicts = conflicts
+}
+
+// baseConfig is the base configuration defined through DF_BASE_CONFIG
+type baseConfig struct {
+	text   []byte
+	values yaml.MapSlice
+}
+
+// readBaseConfig reads the file defined through DF_BASE_CONFIG.
+// It returns nil when the base configuration is not defined.
+func readBaseConfig() (*baseConfig, error) {
+	path := os.Getenv("DF_BASE_CONFIG")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	data, err := afero.ReadFile(FS, path)
+	if err != nil {
+		return nil, err
+	}
+	base := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("%s is not a valid Prometheus config: %s", path, err.Error())
+	}
+	return &baseConfig{text: data, values: base}, nil
+}
+
+// mergeBaseConfig merges the generated config into base.
+// Settings that exist only in base are preserved as they are, lists are extended with the generated items,
+// and scalar values defined in both are kept from base and reported as conflicts.
+func mergeBaseConfig(base yaml.MapSlice, c *Config) (yaml.MapSlice, []ConfigConflict) {
+	generatedYAML, _ := yaml.Marshal(c)
+	generated := yaml.MapSlice{}
+	yaml.Unmarshal(generatedYAML, &generated)
+	conflicts := []ConfigConflict{}
+	merged := mergeMaps(base, generated, "", &conflicts)
+	return merged, conflicts
+}
+
+// baseJobNames returns the names of the jobs defined in the base configuration
+func baseJobNames(base *baseConfig) map[string]bool {
+	names := map[string]bool{}
+	if base == nil {
+		return names
+	}
+	for _, item := range base.values {
+		if item.Key != "scrape_configs" {
+			continue
+		}
+		jobs, _ := item.Value.([]interface{})
+		for _, job := range jobs {
+			if name := mapValue(job, "job_name"); name != nil {
+				names[fmt.Sprint(name)] = true
+			}
+		}
+	}
+	return names
+}
+
+func mergeMaps(base, generated yaml.MapSlice, path string, conflicts *[]ConfigConflict) yaml.MapSlice {
+	merged := append(yaml.MapSlice{}, base...)
+	index := map[string]int{}
+	for i, item := range merged {
+		index[fmt.Sprint(item.Key)] = i
+	}
+	for _, item := range generated {
+		key := fmt.Sprint(item.Key)
+		i, ok := index[key]
+		if !ok {
+			merged = append(merged, item)
+			continue
+		}
+		itemPath := key
+		if len(path) > 0 {
+			itemPath = path + "." + key
+		}
+		merged[i].Value = mergeValues(merged[i].Value, item.Value, itemPath, conflicts)
+	}
+	return merged
+}
+
+func mergeValues(base, generated interface{}, path string, conflicts *[]ConfigConflict) interface{} {
+	switch b := base.(type) {
+	case yaml.MapSlice:
+		if g, ok := generated.(yaml.MapSlice); ok {
+			return mergeMaps(b, g, path, conflicts)
+		}
+	case []interface{}:
+		if g, ok := generated.([]interface{}); ok {
+			return mergeLists(b, g, path, conflicts)
+		}
+	default:
+		if reflect.DeepEqual(base, generated) {
+			return base
+		}
+	}
+	*conflicts = append(*conflicts, ConfigConflict{
+		Path:    path,
+		Message: fmt.Sprintf("The base value %v is used instead of the generated value %v", base, generated),
+	})
+	return base
+}
+
+// mergeLists appends generated items that are not in base.
+// Items of the lists in mergeListKeys that have the same key as an item in base are skipped and reported.
+func mergeLists(base, generated []interface{}, path string, conflicts *[]ConfigConflict) []interface{} {
+	merged := append([]interface{}{}, base...)
+	field, keyed := mergeListKeys[path]
+	for _, g := range generated {
+		if keyed {
+			if key := mapValue(g, field); key != nil && listHasKey(base, field, key) {
+				*conflicts = append(*conflicts, ConfigConflict{
+					Path:    fmt.Sprintf("%s[%s=%v]", path, field, key),
+					Message: fmt.Sprintf("The base config defines %s %v. The generated one is skipped", field, key),
+				})
+				continue
+			}
+		}
+		if !listContains(merged, g) {
+			merged = append(merged, g)
+		}
+	}
+	return merged
+}
+
+func mapValue(item interface{}, key string) interface{} {
+	m, ok := item.(yaml.MapSlice)
+	if !ok {
+		return nil
+	}
+	for _, i := range m {
+		if i.Key == key {
+			return i.Value
+		}
+	}
+	return nil
+}
+
+func listHasKey(list []interface{}, field string, key interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(mapValue(item, field), key) {
+			return true
+		}
+	}
+	return false
+}
+
+func listContains(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// spliceBaseConfig returns text, the base configuration, with the settings that merged adds to base.
+// New entries are appended to the block style maps and lists they belong to and new sections are
+// appended to the end, so comments, anchors, quoting, and formatting of text are kept.
+// A top level section that cannot be spliced, e.g. one written in flow style, is encoded again.
+// When the result does not parse into merged, the whole merged configuration is encoded instead.
+func spliceBaseConfig(text []byte, base, merged yaml.MapSlice) []byte {
+	lines := []string{}
+	if len(strings.TrimSpace(string(text))) > 0 {
+		lines = strings.Split(strings.TrimRight(string(text), "\n"), "\n")
+	}
+	starts := []int{}
+	keys := map[string]int{}
+	for i, line := range lines {
+		if !isContentLine(line) || lineIndent(line) > 0 || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "...") {
+			continue
+		}
+		if key, _, ok := parseKeyLine(line); ok {
+			keys[key] = len(starts)
+			starts = append(starts, i)
+		}
+	}
+	sections := map[int][]string{}
+	for i, item := range merged {
+		if i >= len(base) || reflect.DeepEqual(item.Value, base[i].Value) {
+			continue
+		}
+		key := fmt.Sprint(item.Key)
+		s, ok := keys[key]
+		if !ok {
+			continue
+		}
+		start, end := starts[s], len(lines)
+		if s+1 < len(starts) {
+			end = starts[s+1]
+		}
+		_, rest, _ := parseKeyLine(lines[start])
+		body, err := spliceNode(lines[start+1:end], 0, base[i].Value, item.Value)
+		if isBlockStart(rest) && err == nil {
+			sections[start] = append([]string{lines[start]}, body...)
+		} else {
+			sections[start] = encodeLines(yaml.MapSlice{item}, 0)
+		}
+	}
+	out := []string{}
+	for i := 0; i < len(lines); i++ {
+		section, ok := sections[i]
+		if !ok {
+			out = append(out, lines[i])
+			continue
+		}
+		out = append(out, section...)
+		for i+1 < len(lines) && !isSectionStart(starts, i+1) {
+			i++
+		}
+	}
+	if len(merged) > len(base) {
+		out = append(out, encodeLines(merged[len(base):], 0)...)
+	}
+	spliced := []byte(strings.Join(out, "\n") + "\n")
+	parsed := yaml.MapSlice{}
+	if err := yaml.Unmarshal(spliced, &parsed); err != nil || !reflect.DeepEqual(parsed, merged) {
+		logger.Warn("Unable to keep the formatting of the base config", nil)
+		spliced, _ = yaml.Marshal(merged)
+	}
+	return spliced
+}
+
+// spliceNode appends the entries merged adds to base to body, the lines of a block style map or list
+// nested under a key at indent
+func spliceNode(body []string, indent int, base, merged interface{}) ([]string, error) {
+	first := -1
+	for i, line := range body {
+		if isContentLine(line) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil, fmt.Errorf("The node has no entries")
+	}
+	childIndent := lineIndent(body[first])
+	isList := strings.HasPrefix(strings.TrimSpace(body[first]), "-")
+	result := append([]string{}, body...)
+	added := []string{}
+	switch m := merged.(type) {
+	case []interface{}:
+		b, ok := base.([]interface{})
+		if !ok || !isList || childIndent < indent || len(m) < len(b) || !reflect.DeepEqual(m[:len(b)], b) {
+			return nil, fmt.Errorf("The node is not a block style list")
+		}
+		if len(m) > len(b) {
+			added = encodeLines(m[len(b):], childIndent)
+		}
+	case yaml.MapSlice:
+		b, ok := base.(yaml.MapSlice)
+		if !ok || isList || childIndent <= indent || len(m) < len(b) {
+			return nil, fmt.Errorf("The node is not a block style map")
+		}
+		for j := range b {
+			if m[j].Key != b[j].Key {
+				return nil, fmt.Errorf("The keys of the node changed")
+			}
+			if reflect.DeepEqual(m[j].Value, b[j].Value) {
+				continue
+			}
+			start, end, ok := findChildNode(result, childIndent, fmt.Sprint(b[j].Key))
+			if !ok {
+				return nil, fmt.Errorf("The node %v is not in block style", b[j].Key)
+			}
+			child, err := spliceNode(result[start+1:end], childIndent, b[j].Value, m[j].Value)
+			if err != nil {
+				return nil, err
+			}
+			result = append(append(append([]string{}, result[:start+1]...), child...), result[end:]...)
+		}
+		if len(m) > len(b) {
+			added = encodeLines(m[len(b):], childIndent)
+		}
+	default:
+		return nil, fmt.Errorf("The node is a scalar")
+	}
+	last := len(result) - 1
+	for !isContentLine(result[last]) {
+		last--
+	}
+	return append(append(append([]string{}, result[:last+1]...), added...), result[last+1:]...), nil
+}
+
+// findChildNode returns the line of the block style node key at indent and the line after its last line
+func findChildNode(lines []string, indent int, key string) (int, int, bool) {
+	for i, line := range lines {
+		if !isContentLine(line) || lineIndent(line) != indent {
+			continue
+		}
+		k, rest, ok := parseKeyLine(strings.TrimSpace(line))
+		if !ok || k != key {
+			continue
+		}
+		if !isBlockStart(rest) {
+			return 0, 0, false
+		}
+		end := i + 1
+		for ; end < len(lines); end++ {
+			if !isContentLine(lines[end]) {
+				continue
+			}
+			next := lineIndent(lines[end])
+			if next < indent || (next == indent && !strings.HasPrefix(strings.TrimSpace(lines[end]), "-")) {
+				break
+			}
+		}
+		return i, end, true
+	}
+	return 0, 0, false
+}
+
+var keyLineRegex = regexp.MustCompile(`^(?:"([^"]*)"|'([^']*)'|([^\s#'"\-][^:#]*?))\s*:(\s.*)?$`)
+
+// parseKeyLine returns the key of a line that starts a map entry and the rest of the line after the colon
+func parseKeyLine(line string) (string, string, bool) {
+	match := keyLineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1] + match[2] + match[3], match[4], true
+}
+
+// isBlockStart returns whether the value of a map entry starts on the next line,
+// that is, the rest of its line holds at most an anchor and a comment
+func isBlockStart(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "&") {
+		if i := strings.Index(rest, " "); i > 0 {
+			rest = strings.TrimSpace(rest[i:])
+		} else {
+			rest = ""
+		}
+	}
+	return len(rest) == 0 || strings.HasPrefix(rest, "#")
+}
+
+func isSectionStart(starts []int, line int) bool {
+	for _, start := range starts {
+		if start == line {
+			return true
+		}
+	}
+	return false
+}
+
+func isContentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return len(trimmed) > 0 && !strings.HasPrefix(trimmed, "#")
+}
+
+func lineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// encodeLines encodes value into lines indented by indent spaces
+func encodeLines(value interface{}, indent int) []string {
+	out, _ := yaml.Marshal(value)
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	for i, line := range lines {
+		if len(line) > 0 {
+			lines[i] = strings.Repeat(" ", indent) + line
+		}
+	}
+	return lines
+}
@@ -0,0 +1,259 @@
+package prometheus
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+)
+
+type BaseTestSuite struct {
+	suite.Suite
+	fsOrig afero.Fs
+}
+
+func TestBaseUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(BaseTestSuite))
+}
+
+func (s *BaseTestSuite) SetupTest() {
+	s.fsOrig = FS
+	FS = afero.NewMemMapFs()
+	os.Setenv("DF_BASE_CONFIG", "/run/secrets/prometheus_base")
+}
+
+func (s *BaseTestSuite) TearDownTest() {
+	FS = s.fsOrig
+	os.Unsetenv("DF_BASE_CONFIG")
+	os.Unsetenv("GLOBAL_SCRAPE_INTERVAL")
+	os.Unsetenv("ARG_ALERTMANAGER_URL")
+	os.Unsetenv("DF_CONFIG_LAYOUT")
+	setBaseConfigConflicts([]ConfigConflict{})
+}
+
+// WriteConfig
+
+func (s *BaseTestSuite) Test_WriteConfig_MergesGeneratedConfigIntoBaseConfig() {
+	base := `global:
+  scrape_interval: 30s
+  external_labels:
+    cluster: prod
+alerting:
+  alert_relabel_configs:
+  - action: labeldrop
+    regex: replica
+rule_files:
+- custom.rules
+scrape_configs:
+- job_name: federate
+  honor_labels: true
+  metrics_path: /federate
+  static_configs:
+  - targets:
+    - prometheus-dc2:9090
+storage:
+  exemplars:
+    max_exemplars: 100000
+`
+	afero.WriteFile(FS, "/run/secrets/prometheus_base", []byte(base), 0644)
+	os.Setenv("GLOBAL_SCRAPE_INTERVAL", "30s")
+	os.Setenv("ARG_ALERTMANAGER_URL", "http://alert-manager:9093")
+	scrapes := map[string]Scrape{
+		"my-service": {ServiceName: "my-service", ScrapePort: 1234, ScrapeType: "static_configs"},
+	}
+	alerts := map[string]Alert{
+		"my-servicemyalert": {ServiceName: "my-service", AlertNameFormatted: "myservicemyalert", AlertIf: "a>b"},
+	}
+	expected := `global:
+  scrape_interval: 30s
+  external_labels:
+    cluster: prod
+alerting:
+  alert_relabel_configs:
+  - action: labeldrop
+    regex: replica
+  alertmanagers:
+  - static_configs:
+    - targets:
+      - alert-manager:9093
+    scheme: http
+rule_files:
+- custom.rules
+- alert.rules
+scrape_configs:
+- job_name: federate
+  honor_labels: true
+  metrics_path: /federate
+  static_configs:
+  - targets:
+    - prometheus-dc2:9090
+- job_name: my-service
+  metrics_path: /metrics
+  static_configs:
+  - targets:
+    - my-service:1234
+storage:
+  exemplars:
+    max_exemplars: 100000
+`
+
+	WriteConfig("/etc/prometheus/prometheus.yml", scrapes, alerts, map[string]map[string]string{})
+
+	actual, _ := afero.ReadFile(FS, "/etc/prometheus/prometheus.yml")
+	s.Equal(expected, string(actual))
+	s.Empty(BaseConfigConflicts())
+}
+
+func (s *BaseTestSuite) Test_WriteConfig_KeepsFormattingOfBaseConfig() {
+	base := `# Base config of the monitoring stack
+global:
+  # Labels of the cluster
+  external_labels:
+    cluster: "prod"
+alerting:
+  alert_relabel_configs:
+  - action: 'labeldrop'
+    regex: replica   # added by the HA pair
+scrape_configs:
+  # Federation with the second DC
+  - job_name: federate
+    static_configs: &dc2
+      - targets: ["prometheus-dc2:9090"]
+  - job_name: federate-backup
+    static_configs: *dc2
+
+# Keeps exemplars
+storage:
+  exemplars: {max_exemplars: 100000}
+`
+	afero.WriteFile(FS, "/run/secrets/prometheus_base", []byte(base), 0644)
+	os.Setenv("GLOBAL_SCRAPE_INTERVAL", "30s")
+	os.Setenv("ARG_ALERTMANAGER_URL", "http://alert-manager:9093")
+	scrapes := map[string]Scrape{
+		"my-service": {ServiceName: "my-service", ScrapePort: 1234, ScrapeType: "static_configs"},
+	}
+	expected := `# Base config of the monitoring stack
+global:
+  # Labels of the cluster
+  external_labels:
+    cluster: "prod"
+  scrape_interval: 30s
+alerting:
+  alert_relabel_configs:
+  - action: 'labeldrop'
+    regex: replica   # added by the HA pair
+  alertmanagers:
+  - static_configs:
+    - targets:
+      - alert-manager:9093
+    scheme: http
+scrape_configs:
+  # Federation with the second DC
+  - job_name: federate
+    static_configs: &dc2
+      - targets: ["prometheus-dc2:9090"]
+  - job_name: federate-backup
+    static_configs: *dc2
+  - job_name: my-service
+    metrics_path: /metrics
+    static_configs:
+    - targets:
+      - my-service:1234
+
+# Keeps exemplars
+storage:
+  exemplars: {max_exemplars: 100000}
+`
+
+	WriteConfig("/etc/prometheus/prometheus.yml", scrapes, map[string]Alert{}, map[string]map[string]string{})
+
+	actual, _ := afero.ReadFile(FS, "/etc/prometheus/prometheus.yml")
+	s.Equal(expected, string(actual))
+}
+
+func (s *BaseTestSuite) Test_WriteConfig_EncodesBaseSection_WhenItIsInFlowStyle() {
+	base := `# Rules of the platform team
+rule_files: [custom.rules]
+storage:
+  exemplars: {max_exemplars: 100000}
+`
+	afero.WriteFile(FS, "/run/secrets/prometheus_base", []byte(base), 0644)
+	alerts := map[string]Alert{
+		"my-servicemyalert": {ServiceName: "my-service", AlertNameFormatted: "myservicemyalert", AlertIf: "a>b"},
+	}
+	expected := `# Rules of the platform team
+rule_files:
+- custom.rules
+- alert.rules
+storage:
+  exemplars: {max_exemplars: 100000}
+`
+
+	WriteConfig("/etc/prometheus/prometheus.yml", map[string]Scrape{}, alerts, map[string]map[string]string{})
+
+	actual, _ := afero.ReadFile(FS, "/etc/prometheus/prometheus.yml")
+	s.Equal(expected, string(actual))
+}
+
+func (s *BaseTestSuite) Test_WriteConfig_KeepsBaseValues_WhenTheyConflict() {
+	base := `global:
+  scrape_interval: 30s
+scrape_configs:
+- job_name: my-service
+  static_configs:
+  - targets:
+    - my-service:9999
+`
+	afero.WriteFile(FS, "/run/secrets/prometheus_base", []byte(base), 0644)
+	os.Setenv("GLOBAL_SCRAPE_INTERVAL", "10s")
+	scrapes := map[string]Scrape{
+		"my-service": {ServiceName: "my-service", ScrapePort: 1234, ScrapeType: "static_configs"},
+	}
+
+	WriteConfig("/etc/prometheus/prometheus.yml", scrapes, map[string]Alert{}, map[string]map[string]string{})
+
+	actual, _ := afero.ReadFile(FS, "/etc/prometheus/prometheus.yml")
+	s.Equal(base, string(actual))
+	s.Equal([]ConfigConflict{
+		{Path: "global.scrape_interval", Message: "The base value 30s is used instead of the generated value 10s"},
+		{Path: "scrape_configs[job_name=my-service]", Message: "The base config defines job_name my-service. The generated one is skipped"},
+	}, BaseConfigConflicts())
+}
+
+func (s *BaseTestSuite) Test_WriteConfig_SkipsServiceJobsDefinedInBaseConfig_WhenLayoutIsPerService() {
+	base := `scrape_configs:
+- job_name: my-service
+  static_configs:
+  - targets:
+    - my-service:9999
+`
+	afero.WriteFile(FS, "/run/secrets/prometheus_base", []byte(base), 0644)
+	os.Setenv("DF_CONFIG_LAYOUT", "per-service")
+	scrapes := map[string]Scrape{
+		"my-service":    {ServiceName: "my-service", ScrapePort: 1234},
+		"other-service": {ServiceName: "other-service", ScrapePort: 1234},
+	}
+
+	WriteConfig("/etc/prometheus/prometheus.yml", scrapes, map[string]Alert{}, map[string]map[string]string{})
+
+	exists, _ := afero.Exists(FS, "/etc/prometheus/scrape_configs/my-service.yml")
+	s.False(exists)
+	exists, _ = afero.Exists(FS, "/etc/prometheus/scrape_configs/other-service.yml")
+	s.True(exists)
+	s.Equal([]ConfigConflict{
+		{Path: "scrape_configs[job_name=my-service]", Message: "The base config defines job_name my-service. The generated one is skipped"},
+	}, BaseConfigConflicts())
+}
+
+func (s *BaseTestSuite) Test_WriteConfig_IgnoresBaseConfig_WhenItCannotBeRead() {
+	afero.WriteFile(FS, "/run/secrets/prometheus_base", []byte("not: [valid"), 0644)
+	scrapes := map[string]Scrape{
+		"my-service": {ServiceName: "my-service", ScrapePort: 1234, ScrapeType: "static_configs"},
+	}
+
+	WriteConfig("/etc/prometheus/prometheus.yml", scrapes, map[string]Alert{}, map[string]map[string]string{})
+
+	actual, _ := afero.ReadFile(FS, "/etc/prometheus/prometheus.yml")
+	s.Contains(string(actual), "job_name: my-service")
+}
@@ -2,26 +2,55 @@ package prometheus
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"../logging"
 	"github.com/spf13/afero"
 	"gopkg.in/yaml.v2"
 )
 
-// WriteConfig creates Prometheus configuration at configPath and writes alerts into /etc/prometheus/alert.rules
+// AlertRulesPath is the file WriteConfig writes alert rules into
+var AlertRulesPath = "/etc/prometheus/alert.rules"
+
+// SecretsDir is the directory scrape secrets are referenced from
+var SecretsDir = "/run/secrets"
+
+// ScrapeConfigsDir is the directory WriteConfig writes the jobs of each service into
+// when DF_CONFIG_LAYOUT is set to per-service
+var ScrapeConfigsDir = "/etc/prometheus/scrape_configs"
+
+// RulesDir is the directory WriteConfig writes the alert rules of each service into
+// when DF_CONFIG_LAYOUT is set to per-service
+var RulesDir = "/etc/prometheus/rules"
+
+// fileSDDir is the directory the target groups of file based service discovery are written into
+const fileSDDir = "/etc/prometheus/file_sd"
+
+// WriteConfig creates Prometheus configuration at configPath and writes alerts into AlertRulesPath.
+// When DF_CONFIG_LAYOUT is set to per-service, jobs and alerts of each service are written into
+// their own files in ScrapeConfigsDir and RulesDir and configPath holds only the global settings.
+// Files are rewritten only when their content changes.
+// When DF_BASE_CONFIG is set, the generated configuration is merged into the base configuration from that file.
 func WriteConfig(configPath string, scrapes map[string]Scrape,
 	alerts map[string]Alert, nodeLabels map[string]map[string]string) {
+	if usesPerServiceLayout() {
+		writePerServiceConfig(configPath, scrapes, alerts, nodeLabels)
+		return
+	}
 	c := &Config{}
-	fileSDDir := "/etc/prometheus/file_sd"
-	alertRulesPath := "/etc/prometheus/alert.rules"
+	base := loadBaseConfig()
 
 	configDir := filepath.Dir(configPath)
 	FS.MkdirAll(configDir, 0755)
@@ -34,9 +63,10 @@ func WriteConfig(configPath string, scrapes map[string]Scrape,
 	}
 
 	if len(alerts) > 0 {
-		logPrintf("Writing to alert.rules")
-		afero.WriteFile(FS, alertRulesPath, []byte(GetAlertConfig(alerts)), 0644)
-		c.RuleFiles = []string{"alert.rules"}
+		if writeFileIfChanged(AlertRulesPath, []byte(GetAlertConfig(alerts))) {
+			logger.Info("Writing alert rules", logging.Fields{"path": AlertRulesPath, "alerts": len(alerts)})
+		}
+		c.RuleFiles = []string{filepath.Base(AlertRulesPath)}
 	}
 
 	alertmanagerURLs := os.Getenv("ARG_ALERTMANAGER_URL")
@@ -45,69 +75,475 @@ func WriteConfig(configPath string, scrapes map[string]Scrape,
 	}
 	c.CreateFileStaticConfig(scrapes, nodeLabels, fileSDDir)
 
-	for _, e := range os.Environ() {
-		envSplit := strings.SplitN(e, "=", 2)
-		if len(envSplit) != 2 {
-			continue
+	setBaseConfigConflicts(writeMainConfig(configPath, c, base))
+}
+
+// WriteServiceConfig writes the configuration after the scrapes or alerts of serviceName changed.
+// With the per-service layout, only the files of serviceName are rendered and written, and configPath
+// is regenerated only when the environment, the base configuration or the scrapes in CONFIGS_DIR changed.
+// With the single layout, it is the same as WriteConfig.
+func WriteServiceConfig(configPath, serviceName string, scrapes map[string]Scrape,
+	alerts map[string]Alert, nodeLabels map[string]map[string]string) {
+	if !usesPerServiceLayout() {
+		WriteConfig(configPath, scrapes, alerts, nodeLabels)
+		return
+	}
+	base := loadBaseConfig()
+	perServiceMu.Lock()
+	defer perServiceMu.Unlock()
+	serviceConflicts[serviceName], _ = writeServiceFiles(serviceName, scrapes, alerts, nodeLabels, baseJobNames(base))
+	writeGlobalConfig(configPath, base, false)
+}
+
+// perServiceMu guards the state WriteConfig and WriteServiceConfig keep between writes of the per-service layout
+var perServiceMu sync.Mutex
+
+// serviceConflicts holds the conflicts with the base configuration found in the jobs of each service
+var serviceConflicts = map[string][]ConfigConflict{}
+
+// globalConflicts holds the conflicts with the base configuration found in the global settings
+var globalConflicts = []ConfigConflict{}
+
+// globalConfigInputs is the fingerprint of what the global settings were last generated from
+var globalConfigInputs = ""
+
+// writePerServiceConfig writes the files of all services, removes the files of services that no longer exist,
+// and regenerates configPath
+func writePerServiceConfig(configPath string, scrapes map[string]Scrape,
+	alerts map[string]Alert, nodeLabels map[string]map[string]string) {
+	base := loadBaseConfig()
+	baseJobs := baseJobNames(base)
+	perServiceMu.Lock()
+	defer perServiceMu.Unlock()
+	services := map[string]bool{}
+	for _, s := range scrapes {
+		services[s.ServiceName] = true
+	}
+	for _, a := range alerts {
+		services[a.ServiceName] = true
+	}
+	scrapeFiles, _ := afero.Glob(FS, filepath.Join(ScrapeConfigsDir, "*.yml"))
+	ruleFiles, _ := afero.Glob(FS, filepath.Join(RulesDir, "*.rules"))
+	for _, file := range append(scrapeFiles, ruleFiles...) {
+		name := filepath.Base(file)
+		services[strings.TrimSuffix(name, filepath.Ext(name))] = true
+	}
+	serviceConflicts = map[string][]ConfigConflict{}
+	staticFiles := map[string]bool{}
+	for service := range services {
+		conflicts, files := writeServiceFiles(service, scrapes, alerts, nodeLabels, baseJobs)
+		if len(conflicts) > 0 {
+			serviceConflicts[service] = conflicts
 		}
-		key, value := envSplit[0], envSplit[1]
+		for _, file := range files {
+			staticFiles[file] = true
+		}
+	}
+	currentStaticFiles, _ := afero.Glob(FS, filepath.Join(fileSDDir, "*.json"))
+	for _, file := range currentStaticFiles {
+		if !staticFiles[file] {
+			FS.Remove(file)
+		}
+	}
+	writeGlobalConfig(configPath, base, true)
+}
 
-		if strings.HasPrefix(key, "GLOBAL_") ||
-			strings.HasPrefix(key, "ALERTING_") ||
-			strings.HasPrefix(key, "SCRAPE_CONFIGS_") ||
-			strings.HasPrefix(key, "REMOTE_WRITE_") ||
-			strings.HasPrefix(key, "REMOTE_READ_") {
-			if err := c.InsertEnv(key, value); err != nil {
-				logPrintf("Unable to insert %s into prometheus config", e)
+// writeGlobalConfig writes the global settings of the per-service layout into configPath:
+// the scrapes from CONFIGS_DIR, the Alertmanagers, the settings from environment variables,
+// and the references to ScrapeConfigsDir and RulesDir.
+// Unless force is set, the settings are regenerated only when what they are generated from changed.
+// It must be called with perServiceMu held.
+func writeGlobalConfig(configPath string, base *baseConfig, force bool) {
+	inputs := getGlobalConfigInputs(configPath)
+	if _, err := FS.Stat(configPath); force || err != nil || inputs != globalConfigInputs {
+		c := &Config{}
+		FS.MkdirAll(filepath.Dir(configPath), 0755)
+		c.InsertScrapesFromDir(os.Getenv("CONFIGS_DIR"))
+		c.ScrapeConfigFiles = []string{filepath.Join(filepath.Base(ScrapeConfigsDir), "*.yml")}
+		c.RuleFiles = []string{filepath.Join(filepath.Base(RulesDir), "*.rules")}
+		if alertmanagerURLs := os.Getenv("ARG_ALERTMANAGER_URL"); len(alertmanagerURLs) != 0 {
+			c.InsertAlertManagerURL(alertmanagerURLs)
+		}
+		globalConflicts = writeMainConfig(configPath, c, base)
+		globalConfigInputs = inputs
+	} else {
+		logger.Debug("Global settings did not change", logging.Fields{"path": configPath})
+	}
+	conflicts := []ConfigConflict{}
+	services := []string{}
+	for service := range serviceConflicts {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		conflicts = append(conflicts, serviceConflicts[service]...)
+	}
+	setBaseConfigConflicts(append(conflicts, globalConflicts...))
+}
+
+// getGlobalConfigInputs returns the fingerprint of what the global settings of configPath are generated from:
+// the environment, the base configuration and the scrapes in CONFIGS_DIR
+func getGlobalConfigInputs(configPath string) string {
+	env := os.Environ()
+	sort.Strings(env)
+	h := sha256.New()
+	fmt.Fprintln(h, configPath)
+	for _, e := range env {
+		fmt.Fprintln(h, e)
+	}
+	if path := os.Getenv("DF_BASE_CONFIG"); len(path) > 0 {
+		content, _ := afero.ReadFile(FS, path)
+		h.Write(content)
+	}
+	dir := os.Getenv("CONFIGS_DIR")
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	if files, err := afero.ReadDir(FS, dir); err == nil {
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), "scrape_") {
+				content, _ := afero.ReadFile(FS, dir+file.Name())
+				fmt.Fprintln(h, file.Name())
+				h.Write(content)
 			}
 		}
 	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// loadBaseConfig reads the base configuration and logs the error when it cannot be read
+func loadBaseConfig() *baseConfig {
+	base, err := readBaseConfig()
+	if err != nil {
+		logger.Error("Unable to read the base prometheus config", logging.Fields{"error": err})
+	}
+	return base
+}
+
+// writeMainConfig inserts the settings from environment variables into c, merges it into base, when defined,
+// and writes the result into configPath. It returns the conflicts with the base configuration.
+func writeMainConfig(configPath string, c *Config, base *baseConfig) []ConfigConflict {
+	conflicts := []ConfigConflict{}
+	reportEnvErrors(c.InsertEnvVars())
 
-	logPrintf("Writing to prometheus.yml")
 	configYAML, _ := yaml.Marshal(c)
-	afero.WriteFile(FS, configPath, configYAML, 0644)
+	if base != nil {
+		merged, mergeConflicts := mergeBaseConfig(base.values, c)
+		conflicts = mergeConflicts
+		configYAML = spliceBaseConfig(base.text, base.values, merged)
+	}
+	if writeFileIfChanged(configPath, configYAML) {
+		logger.Info("Writing prometheus config", logging.Fields{"path": configPath, "scrapes": len(c.ScrapeConfigs)})
+	} else {
+		logger.Debug("Prometheus config did not change", logging.Fields{"path": configPath})
+	}
+	return conflicts
+}
+
+// reportedEnvErrors holds the env errors WriteConfig already logged.
+// Environment variables do not change while the process runs, so each error is logged once.
+var reportedEnvErrors = map[string]bool{}
+var reportedEnvErrorsMu sync.Mutex
+
+func reportEnvErrors(errs []EnvError) {
+	reportedEnvErrorsMu.Lock()
+	defer reportedEnvErrorsMu.Unlock()
+	for _, err := range errs {
+		if reportedEnvErrors[err.Error()] {
+			continue
+		}
+		reportedEnvErrors[err.Error()] = true
+		logger.Warn("Skipping environment variable", logging.Fields{"variable": err.Variable, "path": err.Path, "error": err.Message})
+	}
+}
 
+// GeneratedFiles returns the paths of the files WriteConfig created for configPath.
+// The target files used by file based service discovery are not included.
+func GeneratedFiles(configPath string) []string {
+	paths := []string{configPath}
+	if !usesPerServiceLayout() {
+		return append(paths, AlertRulesPath)
+	}
+	scrapeFiles, _ := afero.Glob(FS, filepath.Join(ScrapeConfigsDir, "*.yml"))
+	ruleFiles, _ := afero.Glob(FS, filepath.Join(RulesDir, "*.rules"))
+	paths = append(paths, scrapeFiles...)
+	return append(paths, ruleFiles...)
 }
 
-// InsertEnv inserts envKey/envValue into config
+func usesPerServiceLayout() bool {
+	return os.Getenv("DF_CONFIG_LAYOUT") == "per-service"
+}
+
+// writeServiceFiles writes the jobs of serviceName into [SERVICE_NAME].yml inside ScrapeConfigsDir,
+// the target groups of its jobs into fileSDDir, and its alerts into [SERVICE_NAME].rules inside RulesDir.
+// Files of the service that are no longer needed are removed.
+// Jobs named as one of baseJobs are skipped and reported as conflicts.
+// It returns the conflicts and the target group files of the service.
+func writeServiceFiles(serviceName string, scrapes map[string]Scrape, alerts map[string]Alert,
+	nodeLabels map[string]map[string]string, baseJobs map[string]bool) ([]ConfigConflict, []string) {
+	serviceScrapes := map[string]Scrape{}
+	for name, s := range scrapes {
+		if s.ServiceName == serviceName {
+			serviceScrapes[name] = s
+		}
+	}
+	serviceAlerts := map[string]Alert{}
+	for name, a := range alerts {
+		if a.ServiceName == serviceName {
+			serviceAlerts[name] = a
+		}
+	}
+	scrapePath := filepath.Join(ScrapeConfigsDir, serviceName+".yml")
+	rulesPath := filepath.Join(RulesDir, serviceName+".rules")
+	previousStaticFiles := getStaticFiles(scrapePath)
+	FS.MkdirAll(ScrapeConfigsDir, 0755)
+	FS.MkdirAll(RulesDir, 0755)
+	FS.MkdirAll(fileSDDir, 0755)
+
+	conflicts := []ConfigConflict{}
+	jobs := &Config{}
+	jobs.InsertScrapes(serviceScrapes)
+	staticFiles := jobs.insertTargetGroupScrapes(serviceScrapes, nodeLabels, fileSDDir)
+	f := &ScrapeConfigFile{}
+	for _, sc := range jobs.ScrapeConfigs {
+		if baseJobs[sc.JobName] {
+			conflicts = append(conflicts, ConfigConflict{
+				Path:    fmt.Sprintf("scrape_configs[job_name=%s]", sc.JobName),
+				Message: fmt.Sprintf("The base config defines job_name %s. The generated one is skipped", sc.JobName),
+			})
+			continue
+		}
+		f.ScrapeConfigs = append(f.ScrapeConfigs, sc)
+	}
+
+	written, removed := 0, 0
+	write := func(path string, content []byte, keep bool) {
+		if !keep {
+			if err := FS.Remove(path); err == nil {
+				removed++
+			}
+		} else if writeFileIfChanged(path, content) {
+			written++
+		}
+	}
+	scrapeYAML, _ := yaml.Marshal(f)
+	write(scrapePath, scrapeYAML, len(f.ScrapeConfigs) > 0)
+	write(rulesPath, []byte(getAlertGroupConfig(serviceName, serviceAlerts)), len(serviceAlerts) > 0)
+	files := []string{}
+	for file := range staticFiles {
+		files = append(files, file)
+	}
+	for _, file := range previousStaticFiles {
+		if _, ok := staticFiles[file]; !ok {
+			FS.Remove(file)
+		}
+	}
+	if written > 0 || removed > 0 {
+		logger.Info("Writing service files", logging.Fields{"service": serviceName, "written": written, "removed": removed})
+	}
+	return conflicts, files
+}
+
+// getStaticFiles returns the target group files referenced by the jobs in the scrape config file at path
+func getStaticFiles(path string) []string {
+	files := []string{}
+	content, err := afero.ReadFile(FS, path)
+	if err != nil {
+		return files
+	}
+	f := ScrapeConfigFile{}
+	yaml.Unmarshal(content, &f)
+	for _, sc := range f.ScrapeConfigs {
+		for _, sd := range sc.ServiceDiscoveryConfig.FileSDConfigs {
+			files = append(files, sd.Files...)
+		}
+	}
+	return files
+}
+
+// writeFileIfChanged writes content into path unless the file already has it.
+// It returns true when the file was written.
+func writeFileIfChanged(path string, content []byte) bool {
+	if current, err := afero.ReadFile(FS, path); err == nil && bytes.Equal(current, content) {
+		return false
+	}
+	afero.WriteFile(FS, path, content, 0644)
+	return true
+}
+
+// EnvError describes an environment variable that cannot be inserted into the config.
+// Path is the segment of the variable, in lower case, at which the insert failed.
+type EnvError struct {
+	Variable string `json:"variable"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.Variable, e.Path, e.Message)
+}
+
+// locationError is returned by insertWithLocation when the segment at index cannot be inserted
+type locationError struct {
+	index      int
+	unknownKey bool
+	message    string
+}
+
+func (e *locationError) Error() string {
+	return e.message
+}
+
+// envPrefixes are the prefixes of the environment variables that configure Prometheus
+var envPrefixes = []string{"GLOBAL_", "ALERTING_", "SCRAPE_CONFIGS_", "REMOTE_WRITE_", "REMOTE_READ_"}
+
+// InsertEnv inserts envKey/envValue into config.
+// The returned error is an *EnvError.
 func (c *Config) InsertEnv(envKey string, envValue string) error {
-	envKey, envValue = convertToV2Env(envKey, envValue)
-	envKey = strings.ToLower(envKey)
+	key, value := convertToV2Env(envKey, envValue)
+	key = strings.ToLower(key)
 	obj := reflect.ValueOf(c)
-	location := strings.Split(envKey, "__")
-	return insertWithLocation(obj, location, envValue, 0)
+	location := strings.Split(key, "__")
+	if err := insertWithLocation(obj, location, value, 0); err != nil {
+		envErr := &EnvError{Variable: envKey, Message: err.Error()}
+		if le, ok := err.(*locationError); ok && le.index < len(location) {
+			envErr.Path = location[le.index]
+		}
+		return envErr
+	}
+	return nil
 }
 
-// InsertAlertManagerURL inserts alert into config
-func (c *Config) InsertAlertManagerURL(alertURLs string) {
-	alertURLSlice := strings.Split(alertURLs, ",")
-	schemesToHosts := map[string][]string{}
+// InsertEnvVars inserts all environment variables prefixed with GLOBAL_, ALERTING_, SCRAPE_CONFIGS_,
+// REMOTE_WRITE_, or REMOTE_READ_ into config. Variables are inserted in alphabetical order.
+// It returns an error for each variable that could not be inserted.
+func (c *Config) InsertEnvVars() []EnvError {
+	errs := []EnvError{}
+	envs := os.Environ()
+	sort.Strings(envs)
+	for _, e := range envs {
+		envSplit := strings.SplitN(e, "=", 2)
+		if len(envSplit) != 2 {
+			continue
+		}
+		key, value := envSplit[0], envSplit[1]
+		for _, prefix := range envPrefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if err := c.InsertEnv(key, value); err != nil {
+				errs = append(errs, *err.(*EnvError))
+			}
+			break
+		}
+	}
+	return errs
+}
+
+// ValidateEnv returns an error for each environment variable WriteConfig is unable to insert into the config
+func ValidateEnv() []EnvError {
+	c := &Config{}
+	return c.InsertEnvVars()
+}
 
-	for _, alertURL := range alertURLSlice {
-		url, err := url.Parse(alertURL)
+// InsertAlertManagerURL inserts the alertmanagers defined in alertURLs into config.
+// Each entry of the comma separated alertURLs can define a path prefix, basic auth credentials as user info,
+// and further options as query parameters (see alertmanagerOptions).
+// Alertmanagers with the same settings are grouped in the order their settings first appear in alertURLs.
+// Entries that cannot be parsed are skipped.
+func (c *Config) InsertAlertManagerURL(alertURLs string) {
+	groups := map[string]*AlertmanagerConfig{}
+	for _, alertURL := range strings.Split(alertURLs, ",") {
+		amURL, err := url.Parse(strings.TrimSpace(alertURL))
+		if err == nil && len(amURL.Host) == 0 {
+			err = fmt.Errorf("host is not defined")
+		}
+		var amc *AlertmanagerConfig
+		if err == nil {
+			amc, err = newAlertmanagerConfig(amURL)
+		}
 		if err != nil {
-			logPrintf("Unable to insert alertmanager url %s into prometheus config", alertURL)
+			logger.Warn("Unable to insert alertmanager url into prometheus config", logging.Fields{"url": alertURL, "error": err})
+			continue
+		}
+		key, _ := yaml.Marshal(amc)
+		if group, ok := groups[string(key)]; ok {
+			group.ServiceDiscoveryConfig.StaticConfigs[0].Targets = append(group.ServiceDiscoveryConfig.StaticConfigs[0].Targets, amURL.Host)
+			continue
 		}
-		schemesToHosts[url.Scheme] = append(schemesToHosts[url.Scheme], url.Host)
+		groups[string(key)] = amc
+		amc.ServiceDiscoveryConfig.StaticConfigs = []*TargetGroup{{
+			Targets: []string{amURL.Host},
+		}}
+		c.AlertingConfig.AlertmanagerConfigs = append(c.AlertingConfig.AlertmanagerConfigs, amc)
 	}
+}
 
-	for scheme, hosts := range schemesToHosts {
-		amc := &AlertmanagerConfig{
-			Scheme: scheme,
-			ServiceDiscoveryConfig: ServiceDiscoveryConfig{
-				StaticConfigs: []*TargetGroup{{
-					Targets: hosts,
-				}},
-			},
+// alertmanagerOptions are the query parameters accepted in ARG_ALERTMANAGER_URL entries.
+// Secrets are referenced by their name in SecretsDir.
+var alertmanagerOptions = []string{
+	"timeout", "basicAuthPasswordSecret", "bearerTokenSecret", "proxyURL",
+	"tlsCASecret", "tlsCertSecret", "tlsKeySecret", "tlsServerName", "tlsInsecureSkipVerify",
+}
+
+// newAlertmanagerConfig creates an AlertmanagerConfig without targets from an ARG_ALERTMANAGER_URL entry
+func newAlertmanagerConfig(amURL *url.URL) (*AlertmanagerConfig, error) {
+	query := amURL.Query()
+	for key := range query {
+		known := false
+		for _, option := range alertmanagerOptions {
+			known = known || key == option
+		}
+		if !known {
+			return nil, fmt.Errorf("%s is not an alertmanager option. Use one of %s", key, strings.Join(alertmanagerOptions, ", "))
 		}
-		c.AlertingConfig.AlertmanagerConfigs = append(c.AlertingConfig.AlertmanagerConfigs, amc)
 	}
+	amc := &AlertmanagerConfig{
+		Scheme:     amURL.Scheme,
+		PathPrefix: strings.TrimSuffix(amURL.Path, "/"),
+		Timeout:    query.Get("timeout"),
+	}
+	if amURL.User != nil {
+		if _, ok := amURL.User.Password(); ok && len(query.Get("basicAuthPasswordSecret")) > 0 {
+			return nil, fmt.Errorf("a password in the url cannot be combined with basicAuthPasswordSecret")
+		}
+		if len(query.Get("bearerTokenSecret")) > 0 {
+			return nil, fmt.Errorf("user info in the url cannot be combined with bearerTokenSecret")
+		}
+		amc.HTTPClientConfig.BasicAuth = &BasicAuth{
+			Username:     amURL.User.Username(),
+			PasswordFile: secretPath(query.Get("basicAuthPasswordSecret")),
+		}
+		if password, ok := amURL.User.Password(); ok {
+			amc.HTTPClientConfig.BasicAuth.Password = password
+		}
+	} else if len(query.Get("basicAuthPasswordSecret")) > 0 {
+		return nil, fmt.Errorf("basicAuthPasswordSecret requires a user name in the url")
+	}
+	amc.HTTPClientConfig.BearerTokenFile = secretPath(query.Get("bearerTokenSecret"))
+	amc.HTTPClientConfig.ProxyURL = query.Get("proxyURL")
+	amc.HTTPClientConfig.TLSConfig = TLSConfig{
+		CAFile:     secretPath(query.Get("tlsCASecret")),
+		CertFile:   secretPath(query.Get("tlsCertSecret")),
+		KeyFile:    secretPath(query.Get("tlsKeySecret")),
+		ServerName: query.Get("tlsServerName"),
+	}
+	if value := query.Get("tlsInsecureSkipVerify"); len(value) > 0 {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("tlsInsecureSkipVerify must be true or false")
+		}
+		amc.HTTPClientConfig.TLSConfig.InsecureSkipVerify = insecure
+	}
+	return amc, nil
 }
 
 // InsertScrapes inserts scrapes into config
+// Jobs are ordered by service name so that the output is stable between writes.
 func (c *Config) InsertScrapes(scrapes map[string]Scrape) {
 
-	for _, s := range scrapes {
+	for _, name := range sortedScrapeNames(scrapes) {
+		s := scrapes[name]
 		var newScrape *ScrapeConfig
 		metricsPath := s.MetricsPath
 		if len(metricsPath) == 0 {
@@ -120,25 +556,22 @@ func (c *Config) InsertScrapes(scrapes map[string]Scrape) {
 			newScrape = &ScrapeConfig{
 				ServiceDiscoveryConfig: ServiceDiscoveryConfig{
 					StaticConfigs: []*TargetGroup{{
-						Targets: []string{fmt.Sprintf("%s:%d", s.ServiceName, s.ScrapePort)},
+						Targets: []string{joinHostPort(s.ServiceName, s.ScrapePort)},
 					}},
 				},
 			}
 		} else {
 			newScrape = &ScrapeConfig{
 				ServiceDiscoveryConfig: ServiceDiscoveryConfig{
-					DNSSDConfigs: []*DNSSDConfig{{
-						Names: []string{fmt.Sprintf("tasks.%s", s.ServiceName)},
-						Port:  s.ScrapePort,
-						Type:  "A",
-					}},
+					DNSSDConfigs: []*DNSSDConfig{newDNSSDConfig(s)},
 				},
 			}
 		}
-		newScrape.JobName = s.ServiceName
+		newScrape.JobName = s.JobName()
 		newScrape.MetricsPath = metricsPath
 		newScrape.ScrapeInterval = s.ScrapeInterval
 		newScrape.ScrapeTimeout = s.ScrapeTimeout
+		applyScrapeOptions(newScrape, s)
 		c.ScrapeConfigs = append(c.ScrapeConfigs, newScrape)
 	}
 }
@@ -170,68 +603,179 @@ func (c *Config) InsertScrapesFromDir(dir string) {
 
 }
 
-// CreateFileStaticConfig creates static config files
+// CreateFileStaticConfig creates jobs for scrapes with node info.
+// Target groups are written into files in fileSDDir or, when DF_HTTP_SD_URL is set, served by
+// Docker Flow Monitor through HTTP service discovery. Files that are no longer used are removed.
+// Jobs are ordered by service name and target groups by target so that the output is stable between writes.
 func (c *Config) CreateFileStaticConfig(scrapes map[string]Scrape, nodeLabels map[string]map[string]string, fileSDDir string) {
+	staticFiles := c.insertTargetGroupScrapes(scrapes, nodeLabels, fileSDDir)
 
+	// Remove scrapes that are not in fileStaticServices
+	currentStaticFiles, err := afero.Glob(FS, fmt.Sprintf("%s/*.json", fileSDDir))
+	if err != nil {
+		return
+	}
+	for _, file := range currentStaticFiles {
+		if _, ok := staticFiles[file]; !ok {
+			FS.Remove(file)
+		}
+	}
+}
+
+// insertTargetGroupScrapes creates jobs for scrapes with node info and writes their target groups into fileSDDir.
+// It returns the files it wrote.
+func (c *Config) insertTargetGroupScrapes(scrapes map[string]Scrape, nodeLabels map[string]map[string]string, fileSDDir string) map[string]struct{} {
+	httpSDURL := strings.TrimSuffix(os.Getenv("DF_HTTP_SD_URL"), "/")
 	staticFiles := map[string]struct{}{}
-	for _, s := range scrapes {
-		fsc := FileStaticConfig{}
-		if s.NodeInfo == nil {
+	for _, name := range sortedScrapeNames(scrapes) {
+		s := scrapes[name]
+		fsc := GetTargetGroups(s, nodeLabels)
+		if len(fsc) == 0 {
 			continue
 		}
-		for n := range s.NodeInfo {
-			tg := TargetGroup{}
-			tg.Targets = []string{fmt.Sprintf("%s:%d", n.Addr, s.ScrapePort)}
-			tg.Labels = map[string]string{}
-			if s.ScrapeLabels != nil {
-				for k, v := range *s.ScrapeLabels {
-					tg.Labels[k] = v
-				}
-			}
-			tg.Labels["node"] = n.Name
-			tg.Labels["service"] = s.ServiceName
 
-			// If there is a node id add nodeLabels[n.ID] to service
-			if labels, ok := nodeLabels[n.ID]; len(n.ID) > 0 && ok && labels != nil {
-				for k, v := range labels {
-					tg.Labels[k] = v
-				}
+		newScrape := &ScrapeConfig{
+			JobName:        s.JobName(),
+			MetricsPath:    s.MetricsPath,
+			ScrapeInterval: s.ScrapeInterval,
+			ScrapeTimeout:  s.ScrapeTimeout,
+		}
+		if len(httpSDURL) > 0 {
+			newScrape.ServiceDiscoveryConfig.HTTPSDConfigs = []*HTTPSDConfig{{
+				URL:             fmt.Sprintf("%s/v1/docker-flow-monitor/targets?job=%s", httpSDURL, url.QueryEscape(s.JobName())),
+				RefreshInterval: os.Getenv("DF_HTTP_SD_REFRESH_INTERVAL"),
+			}}
+		} else {
+			fscBytes, err := json.Marshal(fsc)
+			if err != nil {
+				continue
 			}
-			fsc = append(fsc, &tg)
+			filePath := fmt.Sprintf("%s/%s.json", fileSDDir, s.JobName())
+			writeFileIfChanged(filePath, fscBytes)
+			newScrape.ServiceDiscoveryConfig.FileSDConfigs = []*SDConfig{{
+				Files: []string{filePath},
+			}}
+			staticFiles[filePath] = struct{}{}
 		}
+		applyScrapeOptions(newScrape, s)
+		c.ScrapeConfigs = append(c.ScrapeConfigs, newScrape)
+	}
+	return staticFiles
+}
 
-		if len(fsc) == 0 {
+// GetTargetGroups returns a target group for each node address of the scrape, ordered by target.
+// Groups are labeled with the node, the service, the scrape labels and the labels of the node.
+// Scrapes without node info have no target groups.
+func GetTargetGroups(s Scrape, nodeLabels map[string]map[string]string) FileStaticConfig {
+	fsc := FileStaticConfig{}
+	for n := range s.NodeInfo {
+		if !matchesAddressFamily(n.Addr, s.ScrapeAddressFamily) {
 			continue
 		}
-
-		fscBytes, err := json.Marshal(fsc)
-		if err != nil {
-			continue
+		tg := TargetGroup{}
+		tg.Targets = []string{joinHostPort(n.Addr, s.ScrapePort)}
+		tg.Labels = map[string]string{}
+		if s.ScrapeLabels != nil {
+			for k, v := range *s.ScrapeLabels {
+				tg.Labels[k] = v
+			}
 		}
-		filePath := fmt.Sprintf("%s/%s.json", fileSDDir, s.ServiceName)
-		afero.WriteFile(FS, filePath, fscBytes, 0644)
-		newScrape := &ScrapeConfig{
-			ServiceDiscoveryConfig: ServiceDiscoveryConfig{
-				FileSDConfigs: []*SDConfig{{
-					Files: []string{filePath},
-				}},
-			},
-			JobName: s.ServiceName,
+		tg.Labels["node"] = n.Name
+		tg.Labels["service"] = s.ServiceName
+
+		// If there is a node id add nodeLabels[n.ID] to service
+		if labels, ok := nodeLabels[n.ID]; len(n.ID) > 0 && ok && labels != nil {
+			for k, v := range labels {
+				tg.Labels[k] = v
+			}
 		}
-		c.ScrapeConfigs = append(c.ScrapeConfigs, newScrape)
-		staticFiles[filePath] = struct{}{}
+		fsc = append(fsc, &tg)
 	}
+	sort.Slice(fsc, func(i, j int) bool {
+		return fsc[i].Targets[0] < fsc[j].Targets[0]
+	})
+	return fsc
+}
 
-	// Remove scrapes that are not in fileStaticServices
-	currentStaticFiles, err := afero.Glob(FS, fmt.Sprintf("%s/*.json", fileSDDir))
-	if err != nil {
-		return
+// newDNSSDConfig resolves tasks.[SERVICE_NAME] A records unless the scrape defines other names or type.
+// The port is not set for SRV records since they contain it.
+func newDNSSDConfig(s Scrape) *DNSSDConfig {
+	dc := &DNSSDConfig{
+		Names:           s.ScrapeDNSNames,
+		RefreshInterval: s.ScrapeDNSRefreshInterval,
+		Type:            strings.ToUpper(s.ScrapeDNSType),
+		Port:            s.ScrapePort,
 	}
-	for _, file := range currentStaticFiles {
-		if _, ok := staticFiles[file]; !ok {
-			FS.Remove(file)
+	if len(dc.Names) == 0 {
+		dc.Names = []string{fmt.Sprintf("tasks.%s", s.ServiceName)}
+	}
+	if len(dc.Type) == 0 {
+		dc.Type = "A"
+	}
+	if dc.Type == "SRV" {
+		dc.Port = 0
+	}
+	return dc
+}
+
+// applyScrapeOptions sets the options shared by all job types
+func applyScrapeOptions(sc *ScrapeConfig, s Scrape) {
+	sc.HonorLabels = s.ScrapeHonorLabels
+	sc.SampleLimit = s.ScrapeSampleLimit
+	sc.Params = s.ScrapeParams
+	sc.Scheme = s.ScrapeScheme
+	if len(s.ScrapeBasicAuthUsername) > 0 {
+		sc.HTTPClientConfig.BasicAuth = &BasicAuth{
+			Username:     s.ScrapeBasicAuthUsername,
+			PasswordFile: secretPath(s.ScrapeBasicAuthPasswordSecret),
 		}
 	}
+	sc.HTTPClientConfig.BearerTokenFile = secretPath(s.ScrapeBearerTokenSecret)
+	sc.HTTPClientConfig.ProxyURL = s.ScrapeProxyURL
+	sc.HTTPClientConfig.TLSConfig = TLSConfig{
+		CAFile:     secretPath(s.ScrapeTLSCASecret),
+		CertFile:   secretPath(s.ScrapeTLSCertSecret),
+		KeyFile:    secretPath(s.ScrapeTLSKeySecret),
+		ServerName: s.ScrapeTLSServerName,
+	}
+	sc.RelabelConfigs = s.RelabelConfigs
+	sc.MetricRelabelConfigs = s.MetricRelabelConfigs
+}
+
+// secretPath returns the path of the secret with the given name or an empty string when name is empty
+func secretPath(name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	return filepath.Join(SecretsDir, name)
+}
+
+// joinHostPort returns host:port, enclosing IPv6 addresses in square brackets
+func joinHostPort(host string, port int) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// matchesAddressFamily returns true when addr is an address of family (ipv4 or ipv6).
+// Host names and addresses match when family is empty.
+func matchesAddressFamily(addr, family string) bool {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+	switch strings.ToLower(family) {
+	case "ipv4":
+		return ip != nil && ip.To4() != nil
+	case "ipv6":
+		return ip != nil && ip.To4() == nil
+	}
+	return true
+}
+
+func sortedScrapeNames(scrapes map[string]Scrape) []string {
+	names := make([]string, 0, len(scrapes))
+	for name := range scrapes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func normalizeScrapeFile(content []byte) []byte {
@@ -288,34 +832,38 @@ func insertWithLocation(obj reflect.Value, location []string, value string, inde
 }
 
 func insertWithLocationDefault(obj reflect.Value, location []string, value string, index int) error {
-	objI := obj.Interface()
-	switch objI.(type) {
-	case string:
+	if index < len(location) {
+		return &locationError{index: index, message: fmt.Sprintf("cannot be set because %s is not a section", location[index-1])}
+	}
+	switch obj.Kind() {
+	case reflect.String:
 		obj.SetString(value)
-	case bool:
+	case reflect.Bool:
 		v, err := strconv.ParseBool(value)
 		if err != nil {
-			return fmt.Errorf("Non bool value")
+			return &locationError{index: index - 1, message: fmt.Sprintf("expects a bool but got %s", value)}
 		}
-		obj.Set(reflect.ValueOf(v))
-	case int:
-		v, err := strconv.Atoi(value)
+		obj.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(value, 10, obj.Type().Bits())
 		if err != nil {
-			return fmt.Errorf("Non int value")
+			return &locationError{index: index - 1, message: fmt.Sprintf("expects an integer but got %s", value)}
 		}
-		obj.Set(reflect.ValueOf(v))
-	case uint64:
-		v, err := strconv.Atoi(value)
+		obj.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(value, 10, obj.Type().Bits())
 		if err != nil {
-			return fmt.Errorf("Non int value")
+			return &locationError{index: index - 1, message: fmt.Sprintf("expects a positive integer but got %s", value)}
 		}
-		obj.Set(reflect.ValueOf(uint64(v)))
-	case uint:
-		v, err := strconv.Atoi(value)
+		obj.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, obj.Type().Bits())
 		if err != nil {
-			return fmt.Errorf("Non int value")
+			return &locationError{index: index - 1, message: fmt.Sprintf("expects a number but got %s", value)}
 		}
-		obj.Set(reflect.ValueOf(uint(v)))
+		obj.SetFloat(v)
+	default:
+		return &locationError{index: index - 1, message: fmt.Sprintf("has the unsupported type %s", obj.Kind())}
 	}
 	return nil
 }
@@ -332,7 +880,7 @@ func insertWithLocationPtr(obj reflect.Value, location []string, value string, i
 func insertWithLocationStruct(obj reflect.Value, location []string, value string, index int) error {
 	t := reflect.TypeOf(obj.Interface())
 	if index >= len(location) {
-		return fmt.Errorf("incorrect env location")
+		return &locationError{index: index - 1, message: fmt.Sprintf("is a section. Use one of the keys %s", strings.Join(yamlKeys(t), ", "))}
 	}
 	targetTag := location[index]
 	for i := 0; i < t.NumField(); i++ {
@@ -361,26 +909,27 @@ func insertWithLocationStruct(obj reflect.Value, location []string, value string
 		if tagsSplit[len(tagsSplit)-1] == "inline" {
 			v := obj.Field(i)
 			err := insertWithLocation(v, location, value, index)
-			if err != nil {
+			if le, ok := err.(*locationError); ok && le.unknownKey && le.index == index {
 				continue
 			}
+			return err
 		}
 	}
-	return fmt.Errorf("Unable to find tag: %s", targetTag)
+	return &locationError{index: index, unknownKey: true, message: fmt.Sprintf("is not a valid key. Use one of %s", strings.Join(yamlKeys(t), ", "))}
 }
 
 func insertWithLocationSlice(obj reflect.Value, location []string, value string, index int) error {
 	if index >= len(location) {
-		return fmt.Errorf("Incorrect env location")
+		return &locationError{index: index - 1, message: "is a list. Append the position of the item, e.g. _1"}
 	}
 	targetTag := location[index]
 	sliceTag := sliceRegex.FindAllStringSubmatch(targetTag, 1)
 	if len(sliceTag) == 0 || len(sliceTag[0]) != 3 {
-		return fmt.Errorf("Array tag must be of the form: label_NUM")
+		return &locationError{index: index - 1, message: "is a list. Append the position of the item, e.g. _1"}
 	}
 	indexValue, err := strconv.Atoi(sliceTag[0][2])
-	if err != nil {
-		return fmt.Errorf("Array tag must end with a number")
+	if err != nil || indexValue < 1 {
+		return &locationError{index: index, message: "must end with a position starting at 1"}
 	}
 	if obj.Len() < indexValue {
 		newVP := reflect.New(obj.Type()).Elem()
@@ -395,7 +944,7 @@ func insertWithLocationMap(obj reflect.Value, location []string, value string, i
 	// All Maps are map[string]string or map[string][]string
 	keyValue := strings.Split(value, "=")
 	if len(keyValue) != 2 {
-		return fmt.Errorf("Value for map must be of the form: key=value")
+		return &locationError{index: index - 1, message: fmt.Sprintf("expects a value of the form key=value but got %s", value)}
 	}
 	if obj.IsNil() {
 		obj.Set(reflect.MakeMap(obj.Type()))
@@ -427,3 +976,17 @@ func insertWithLocationMap(obj reflect.Value, location []string, value string, i
 	obj.SetMapIndex(reflect.ValueOf(key), newV)
 	return nil
 }
+
+// yamlKeys returns the yaml keys of the fields of t, including the fields of inlined structs
+func yamlKeys(t reflect.Type) []string {
+	keys := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		tagsSplit := strings.Split(t.Field(i).Tag.Get("yaml"), ",")
+		if tagsSplit[len(tagsSplit)-1] == "inline" {
+			keys = append(keys, yamlKeys(t.Field(i).Type)...)
+		} else if len(tagsSplit[0]) > 0 && tagsSplit[0] != "-" {
+			keys = append(keys, tagsSplit[0])
+		}
+	}
+	return keys
+}
@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var relabelActions = []string{"replace", "keep", "drop", "hashmod", "labelmap", "labeldrop", "labelkeep"}
+
+// relabelKeys maps accepted keys, in camel and snake case, to RelabelConfig fields
+var relabelKeys = map[string]string{
+	"sourceLabels":  "sourceLabels",
+	"source_labels": "sourceLabels",
+	"separator":     "separator",
+	"regex":         "regex",
+	"modulus":       "modulus",
+	"targetLabel":   "targetLabel",
+	"target_label":  "targetLabel",
+	"replacement":   "replacement",
+	"action":        "action",
+}
+
+var relabelKeyRegex = regexp.MustCompile(`^([a-zA-Z_]+)=`)
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// IsValidLabelName returns true when name can be used as a Prometheus label name
+func IsValidLabelName(name string) bool {
+	return labelNameRegex.MatchString(name)
+}
+
+// ParseRelabelConfig creates a RelabelConfig from a comma separated list of key=value pairs.
+// A comma starts a new pair only when it is followed by a known key, so source labels
+// and regular expressions can contain commas.
+// For example, sourceLabels=__name__,regex=go_gc_.*,action=drop.
+func ParseRelabelConfig(value string) (*RelabelConfig, error) {
+	rc := &RelabelConfig{}
+	for _, pair := range splitRelabelPairs(value) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s is not in the key=value format", pair)
+		}
+		key, ok := relabelKeys[kv[0]]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a relabel key", kv[0])
+		}
+		switch key {
+		case "sourceLabels":
+			rc.SourceLabels = strings.Split(kv[1], ",")
+		case "separator":
+			rc.Separator = kv[1]
+		case "regex":
+			rc.Regex = kv[1]
+		case "modulus":
+			modulus, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("modulus must be a positive number")
+			}
+			rc.Modulus = modulus
+		case "targetLabel":
+			rc.TargetLabel = kv[1]
+		case "replacement":
+			rc.Replacement = kv[1]
+		case "action":
+			rc.Action = strings.ToLower(kv[1])
+		}
+	}
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Validate returns an error when Prometheus would reject the relabel config
+func (rc *RelabelConfig) Validate() error {
+	action := rc.Action
+	if len(action) == 0 {
+		action = "replace"
+	}
+	valid := false
+	for _, a := range relabelActions {
+		if a == action {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("%s is not a relabel action. Use one of %s", rc.Action, strings.Join(relabelActions, ", "))
+	}
+	if len(rc.Regex) > 0 {
+		if _, err := regexp.Compile("^(?:" + rc.Regex + ")$"); err != nil {
+			return fmt.Errorf("regex %s is not valid: %s", rc.Regex, err.Error())
+		}
+	}
+	for _, label := range rc.SourceLabels {
+		if !labelNameRegex.MatchString(label) {
+			return fmt.Errorf("%s is not a valid label name", label)
+		}
+	}
+	switch action {
+	case "replace", "hashmod":
+		if len(rc.TargetLabel) == 0 {
+			return fmt.Errorf("targetLabel is required for the %s action", action)
+		}
+	case "keep", "drop":
+		if len(rc.SourceLabels) == 0 {
+			return fmt.Errorf("sourceLabels are required for the %s action", action)
+		}
+	case "labeldrop", "labelkeep":
+		if len(rc.Regex) == 0 {
+			return fmt.Errorf("regex is required for the %s action", action)
+		}
+	}
+	if action == "hashmod" && rc.Modulus == 0 {
+		return fmt.Errorf("modulus is required for the hashmod action")
+	}
+	return nil
+}
+
+func splitRelabelPairs(value string) []string {
+	pairs := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] != ',' {
+			continue
+		}
+		if m := relabelKeyRegex.FindStringSubmatch(value[i+1:]); m != nil {
+			if _, ok := relabelKeys[m[1]]; ok {
+				pairs = append(pairs, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(pairs, value[start:])
+}
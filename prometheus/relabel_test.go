@@ -0,0 +1,66 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RelabelTestSuite struct {
+	suite.Suite
+}
+
+func TestRelabelUnitTestSuite(t *testing.T) {
+	suite.Run(t, new(RelabelTestSuite))
+}
+
+// ParseRelabelConfig
+
+func (s *RelabelTestSuite) Test_ParseRelabelConfig_ReturnsRelabelConfig() {
+	actual, err := ParseRelabelConfig("sourceLabels=__name__,job,separator=;,regex=go_(gc|memstats)_.*;my-service,action=drop")
+
+	s.Require().NoError(err)
+	s.Equal(&RelabelConfig{
+		SourceLabels: []string{"__name__", "job"},
+		Separator:    ";",
+		Regex:        "go_(gc|memstats)_.*;my-service",
+		Action:       "drop",
+	}, actual)
+}
+
+func (s *RelabelTestSuite) Test_ParseRelabelConfig_KeepsCommasInValues() {
+	actual, err := ParseRelabelConfig("source_labels=a,b,regex=(x{1,3}),y,target_label=c,replacement=$1,$2")
+
+	s.Require().NoError(err)
+	s.Equal([]string{"a", "b"}, actual.SourceLabels)
+	s.Equal("(x{1,3}),y", actual.Regex)
+	s.Equal("c", actual.TargetLabel)
+	s.Equal("$1,$2", actual.Replacement)
+	s.Empty(actual.Action)
+}
+
+func (s *RelabelTestSuite) Test_ParseRelabelConfig_ParsesModulus() {
+	actual, err := ParseRelabelConfig("sourceLabels=__address__,modulus=4,targetLabel=__tmp_hash,action=hashmod")
+
+	s.Require().NoError(err)
+	s.Equal(uint64(4), actual.Modulus)
+}
+
+func (s *RelabelTestSuite) Test_ParseRelabelConfig_ReturnsError_WhenConfigIsInvalid() {
+	for _, value := range []string{
+		"",
+		"regex",
+		"color=red",
+		"sourceLabels=a,action=explode",
+		"sourceLabels=a,regex=(,action=drop",
+		"sourceLabels=not-a-label,action=drop",
+		"regex=.*,action=drop",
+		"sourceLabels=a,regex=(.*)",
+		"action=labeldrop",
+		"sourceLabels=a,targetLabel=b,action=hashmod",
+		"sourceLabels=a,targetLabel=b,modulus=-1,action=hashmod",
+	} {
+		_, err := ParseRelabelConfig(value)
+		s.Error(err, value)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"os"
 	"os/exec"
 	"sync"
+
+	"../logging"
 )
 
 var mu = &sync.Mutex{}
@@ -12,14 +14,15 @@ var mu = &sync.Mutex{}
 var Reload = func() error {
 	mu.Lock()
 	defer mu.Unlock()
-	logPrintf("Reloading Prometheus")
+	logger.Info("Reloading Prometheus", nil)
 	cmd := exec.Command("pkill", "-HUP", "prometheus")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmdRun(cmd)
 	if err != nil {
-		logPrintf(err.Error())
+		logger.Error("Prometheus reload failed", logging.Fields{"error": err})
+		return err
 	}
-	logPrintf("Prometheus was reloaded")
-	return err
+	logger.Info("Prometheus was reloaded", nil)
+	return nil
 }
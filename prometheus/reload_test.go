@@ -2,9 +2,12 @@ package prometheus
 
 import (
 	"fmt"
-	"github.com/stretchr/testify/suite"
+	"io/ioutil"
 	"os/exec"
 	"testing"
+
+	"../logging"
+	"github.com/stretchr/testify/suite"
 )
 
 type ReloadTestSuite struct {
@@ -16,9 +19,9 @@ func (s *ReloadTestSuite) SetupTest() {
 
 func TestReloadUnitTestSuite(t *testing.T) {
 	s := new(ReloadTestSuite)
-	logPrintlnOrig := logPrintf
-	defer func() { logPrintf = logPrintlnOrig }()
-	logPrintf = func(format string, v ...interface{}) {}
+	loggerOrig := logger
+	defer func() { logger = loggerOrig }()
+	logger = logging.New(ioutil.Discard, "", logging.InfoLevel)
 	suite.Run(t, s)
 }
 
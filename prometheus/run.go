@@ -1,15 +1,20 @@
 package prometheus
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"../logging"
 )
 
 // Run starts `prometheus` process
 var Run = func() error {
-	logPrintf("Starting Prometheus")
+	logger.Info("Starting Prometheus", nil)
 	cmdString := "prometheus"
 	flags := EnvToPrometheusFlags("ARG")
 	if len(flags) > 0 {
@@ -21,3 +26,65 @@ var Run = func() error {
 	cmd.Stderr = os.Stderr
 	return cmdRun(cmd)
 }
+
+// MinPerServiceLayoutVersion is the oldest version of Prometheus that loads scrape_config_files
+// used by the per-service layout
+var MinPerServiceLayoutVersion = []int{2, 43, 0}
+
+var versionRegex = regexp.MustCompile(`version (\d+)\.(\d+)\.(\d+)`)
+
+// Version returns the major, minor and patch version of the `prometheus` binary
+func Version() ([]int, error) {
+	out := bytes.Buffer{}
+	cmd := exec.Command("prometheus", "--version")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmdRun(cmd); err != nil {
+		return nil, err
+	}
+	match := versionRegex.FindStringSubmatch(out.String())
+	if match == nil {
+		return nil, fmt.Errorf("Unable to find the version in the output of prometheus --version")
+	}
+	version := []int{}
+	for _, part := range match[1:] {
+		v, _ := strconv.Atoi(part)
+		version = append(version, v)
+	}
+	return version, nil
+}
+
+// ValidateLayout returns an error when DF_CONFIG_LAYOUT is set to per-service
+// and the `prometheus` binary is older than MinPerServiceLayoutVersion.
+// The layout is not rejected when the version cannot be determined.
+func ValidateLayout() error {
+	if !usesPerServiceLayout() {
+		return nil
+	}
+	version, err := Version()
+	if err != nil {
+		logger.Warn("Unable to determine the version of Prometheus", logging.Fields{"error": err})
+		return nil
+	}
+	for i := range version {
+		if version[i] != MinPerServiceLayoutVersion[i] {
+			if version[i] > MinPerServiceLayoutVersion[i] {
+				return nil
+			}
+			return fmt.Errorf(
+				"DF_CONFIG_LAYOUT per-service requires Prometheus %s or newer, found %s",
+				formatVersion(MinPerServiceLayoutVersion),
+				formatVersion(version),
+			)
+		}
+	}
+	return nil
+}
+
+func formatVersion(version []int) string {
+	parts := []string{}
+	for _, v := range version {
+		parts = append(parts, strconv.Itoa(v))
+	}
+	return strings.Join(parts, ".")
+}
@@ -1,10 +1,13 @@
 package prometheus
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"testing"
 
+	"../logging"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -17,9 +20,9 @@ func (s *RunTestSuite) SetupTest() {
 
 func TestRunUnitTestSuite(t *testing.T) {
 	s := new(RunTestSuite)
-	logPrintlnOrig := logPrintf
-	defer func() { logPrintf = logPrintlnOrig }()
-	logPrintf = func(format string, v ...interface{}) {}
+	loggerOrig := logger
+	defer func() { logger = loggerOrig }()
+	logger = logging.New(ioutil.Discard, "", logging.InfoLevel)
 	os.Setenv("GLOBAL_SCRAPE_INTERVAL", "5s")
 	os.Setenv("ARG_CONFIG_FILE", "/etc/prometheus/prometheus.yml")
 	os.Setenv("ARG_STORAGE_LOCAL_PATH", "/prometheus")
@@ -86,3 +89,50 @@ func (s *RunTestSuite) Test_Run_ReturnsError() {
 
 	s.Error(err)
 }
+
+// ValidateLayout
+
+func (s *RunTestSuite) Test_ValidateLayout_ReturnsError_WhenPrometheusIsOlderThanMinVersion() {
+	cmdRunOrig := cmdRun
+	defer func() {
+		cmdRun = cmdRunOrig
+		os.Unsetenv("DF_CONFIG_LAYOUT")
+	}()
+	os.Setenv("DF_CONFIG_LAYOUT", "per-service")
+	testData := map[string]bool{
+		"2.25.2": true,
+		"2.42.9": true,
+		"2.43.0": false,
+		"2.53.1": false,
+		"3.0.0":  false,
+	}
+
+	for version, expectError := range testData {
+		actualArgs := []string{}
+		cmdRun = func(cmd *exec.Cmd) error {
+			actualArgs = cmd.Args
+			fmt.Fprintf(cmd.Stdout, "prometheus, version %s (branch: HEAD, revision: abc)\n", version)
+			return nil
+		}
+
+		err := ValidateLayout()
+
+		s.Equal([]string{"prometheus", "--version"}, actualArgs)
+		if expectError {
+			s.EqualError(err, "DF_CONFIG_LAYOUT per-service requires Prometheus 2.43.0 or newer, found "+version)
+		} else {
+			s.NoError(err, version)
+		}
+	}
+}
+
+func (s *RunTestSuite) Test_ValidateLayout_ReturnsNil_WhenLayoutIsSingle() {
+	cmdRunOrig := cmdRun
+	defer func() { cmdRun = cmdRunOrig }()
+	cmdRun = func(cmd *exec.Cmd) error {
+		fmt.Fprintln(cmd.Stdout, "prometheus, version 2.25.2 (branch: HEAD, revision: abc)")
+		return nil
+	}
+
+	s.NoError(ValidateLayout())
+}
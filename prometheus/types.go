@@ -1,6 +1,9 @@
 package prometheus
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // ScrapeConfig configures a scraping unit for Prometheus.
 type ScrapeConfig struct {
@@ -93,7 +96,7 @@ type DNSSDConfig struct {
 	Names           []string `yaml:"names"`
 	RefreshInterval string   `yaml:"refresh_interval,omitempty"`
 	Type            string   `yaml:"type"`
-	Port            int      `yaml:"port"` // Ignored for SRV records
+	Port            int      `yaml:"port,omitempty"` // Ignored for SRV records
 }
 
 // SDConfig is the configuration for file based discovery.
@@ -102,6 +105,12 @@ type SDConfig struct {
 	RefreshInterval string   `yaml:"refresh_interval,omitempty"`
 }
 
+// HTTPSDConfig is the configuration for HTTP based discovery.
+type HTTPSDConfig struct {
+	URL             string `yaml:"url"`
+	RefreshInterval string `yaml:"refresh_interval,omitempty"`
+}
+
 // FileStaticConfig configures File-based service discovery
 type FileStaticConfig []*TargetGroup
 
@@ -113,12 +122,15 @@ type ServiceDiscoveryConfig struct {
 	DNSSDConfigs []*DNSSDConfig `yaml:"dns_sd_configs,omitempty"`
 	// List of file service discovery configurations.
 	FileSDConfigs []*SDConfig `yaml:"file_sd_configs,omitempty"`
+	// List of HTTP service discovery configurations.
+	HTTPSDConfigs []*HTTPSDConfig `yaml:"http_sd_configs,omitempty"`
 }
 
 // BasicAuth contains basic HTTP authentication credentials.
 type BasicAuth struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
 }
 
 // TLSConfig configures the options for TLS connections.
@@ -206,15 +218,21 @@ type GlobalConfig struct {
 
 // Config is the top-level configuration for Prometheus's config files.
 type Config struct {
-	GlobalConfig   GlobalConfig    `yaml:"global,omitempty"`
-	AlertingConfig AlertingConfig  `yaml:"alerting,omitempty"`
-	RuleFiles      []string        `yaml:"rule_files,omitempty"`
-	ScrapeConfigs  []*ScrapeConfig `yaml:"scrape_configs,omitempty"`
+	GlobalConfig      GlobalConfig    `yaml:"global,omitempty"`
+	AlertingConfig    AlertingConfig  `yaml:"alerting,omitempty"`
+	RuleFiles         []string        `yaml:"rule_files,omitempty"`
+	ScrapeConfigFiles []string        `yaml:"scrape_config_files,omitempty"`
+	ScrapeConfigs     []*ScrapeConfig `yaml:"scrape_configs,omitempty"`
 
 	RemoteWriteConfigs []*RemoteWriteConfig `yaml:"remote_write,omitempty"`
 	RemoteReadConfigs  []*RemoteReadConfig  `yaml:"remote_read,omitempty"`
 }
 
+// ScrapeConfigFile is the content of a file referenced from scrape_config_files
+type ScrapeConfigFile struct {
+	ScrapeConfigs []*ScrapeConfig `yaml:"scrape_configs"`
+}
+
 // Alert defines data used to create alert configuration snippet
 type Alert struct {
 	AlertAnnotations   map[string]string `json:"alertAnnotations,omitempty"`
@@ -303,4 +321,43 @@ type Scrape struct {
 	ScrapeType     string             `json:"scrapeType"`
 	ServiceName    string             `json:"serviceName"`
 	NodeInfo       NodeIPSet          `json:"nodeInfo,omitempty"`
+	// Secrets are names of files in SecretsDir
+	ScrapeScheme                  string `json:"scrapeScheme,omitempty"`
+	ScrapeBasicAuthUsername       string `json:"scrapeBasicAuthUsername,omitempty"`
+	ScrapeBasicAuthPasswordSecret string `json:"scrapeBasicAuthPasswordSecret,omitempty"`
+	ScrapeBearerTokenSecret       string `json:"scrapeBearerTokenSecret,omitempty"`
+	ScrapeTLSCASecret             string `json:"scrapeTlsCaSecret,omitempty"`
+	ScrapeTLSCertSecret           string `json:"scrapeTlsCertSecret,omitempty"`
+	ScrapeTLSKeySecret            string `json:"scrapeTlsKeySecret,omitempty"`
+	ScrapeTLSServerName           string `json:"scrapeTlsServerName,omitempty"`
+	ScrapeProxyURL                string `json:"scrapeProxyUrl,omitempty"`
+
+	// DNS service discovery options that are ignored by static_configs and file_sd jobs
+	ScrapeDNSType            string   `json:"scrapeDnsType,omitempty"`
+	ScrapeDNSNames           []string `json:"scrapeDnsNames,omitempty" schema:"-"`
+	ScrapeDNSRefreshInterval string   `json:"scrapeDnsRefreshInterval,omitempty"`
+
+	// Limits file_sd targets to node addresses of the family, ipv4 or ipv6
+	ScrapeAddressFamily string `json:"scrapeAddressFamily,omitempty"`
+
+	// Parsed from the scrapeHonorLabels, scrapeSampleLimit and scrapeParams parameters
+	ScrapeHonorLabels bool                `json:"scrapeHonorLabels,omitempty" schema:"-"`
+	ScrapeSampleLimit uint                `json:"scrapeSampleLimit,omitempty" schema:"-"`
+	ScrapeParams      map[string][]string `json:"scrapeParams,omitempty" schema:"-"`
+
+	// Set through the relabel.N and metricRelabel.N parameters
+	RelabelConfigs       []*RelabelConfig `json:"relabelConfigs,omitempty" schema:"-"`
+	MetricRelabelConfigs []*RelabelConfig `json:"metricRelabelConfigs,omitempty" schema:"-"`
+
+	// The N of the scrapePort.N parameter the scrape was created from or zero
+	ScrapeIndex int `json:"scrapeIndex,omitempty" schema:"-"`
+}
+
+// JobName returns the name of the Prometheus job of the scrape.
+// Scrapes created from indexed ports are named [SERVICE_NAME]_[INDEX].
+func (s Scrape) JobName() string {
+	if s.ScrapeIndex > 0 {
+		return fmt.Sprintf("%s_%d", s.ServiceName, s.ScrapeIndex)
+	}
+	return s.ServiceName
 }
@@ -1,17 +1,17 @@
 package prometheus
 
 import (
-	"log"
 	"os/exec"
 	"strings"
 
+	"../logging"
 	"github.com/spf13/afero"
 )
 
 // FS defines file system used to read and write configuration files
 var FS = afero.NewOsFs()
 
-var logPrintf = log.Printf
+var logger = logging.Default
 
 func getArgFromEnv(env, prefix string) (key, value string) {
 	if strings.HasPrefix(env, prefix+"_") {
@@ -33,6 +33,6 @@ func getArgFromEnv(env, prefix string) (key, value string) {
 }
 
 var cmdRun = func(cmd *exec.Cmd) error {
-	logPrintf(strings.Join(cmd.Args, " "))
+	logger.Info("Running command", logging.Fields{"cmd": strings.Join(cmd.Args, " ")})
 	return cmd.Run()
 }
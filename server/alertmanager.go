@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"../alertmanager"
+	"../logging"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var receiversPath = "/etc/dfm/receivers.yaml"
+
+// readReceiverCatalogue reads the receiver catalogue from the file defined through
+// DF_ALERTMANAGER_RECEIVERS or from /etc/dfm/receivers.yaml
+func readReceiverCatalogue() *alertmanager.Catalogue {
+	path := receiversPath
+	if len(os.Getenv("DF_ALERTMANAGER_RECEIVERS")) > 0 {
+		path = os.Getenv("DF_ALERTMANAGER_RECEIVERS")
+	}
+	data, err := afero.ReadFile(FS, path)
+	if err != nil {
+		logger.Debug("No receiver catalogue is configured", logging.Fields{"path": path})
+		return nil
+	}
+	catalogue, err := alertmanager.ParseCatalogue(data)
+	if err != nil {
+		logger.Error("Unable to decode receiver catalogue", logging.Fields{"path": path, "error": err})
+		return nil
+	}
+	return catalogue
+}
+
+// AlertmanagerConfigHandler returns the Alertmanager configuration generated from the receiver catalogue
+// and the labels of the registered alerts
+func (s *serve) AlertmanagerConfigHandler(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+	config, unrouted, err := s.generateAlertmanagerConfig(w)
+	if err != nil {
+		return
+	}
+	for _, a := range unrouted {
+		logger.Warn("Alert matches no route", logging.Fields{"service": a.Service, "alert": a.Alert})
+	}
+	w.Header().Set("Content-Type", "text/yaml")
+	w.WriteHeader(http.StatusOK)
+	out, _ := yaml.Marshal(config)
+	w.Write(out)
+}
+
+// AlertmanagerUnroutedHandler returns the alerts that match no route other than the root route
+func (s *serve) AlertmanagerUnroutedHandler(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+	_, unrouted, err := s.generateAlertmanagerConfig(w)
+	if err != nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, unrouted)
+}
+
+// generateAlertmanagerConfig writes an error response when the configuration cannot be generated
+func (s *serve) generateAlertmanagerConfig(w http.ResponseWriter) (*alertmanager.Config, []alertmanager.UnroutedAlert, error) {
+	var config *alertmanager.Config
+	var unrouted []alertmanager.UnroutedAlert
+	resp := response{Status: http.StatusInternalServerError}
+	err := fmt.Errorf("No receiver catalogue is configured")
+	if s.receivers == nil {
+		resp.Status = http.StatusNotFound
+	} else {
+		config, unrouted, err = alertmanager.Generate(s.receivers, s.alerts)
+	}
+	if err != nil {
+		resp.Message = err.Error()
+		writeJSON(w, resp.Status, resp)
+	}
+	return config, unrouted, err
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"../alertmanager"
+	"../prometheus"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var testReceivers = `route:
+  receiver: slack
+receivers:
+- name: slack
+- name: "jenkins-[[ .service ]]-[[ .scale ]]"
+  matchRe:
+    scale: up|down
+  expand: [service, scale]
+`
+
+// AlertmanagerConfigHandler
+
+func (s *ServerTestSuite) Test_AlertmanagerConfigHandler_ReturnsGeneratedConfig() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	s.writeReceivers(testReceivers)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&alertName=up&alertIf=a>b&alertLabels=scale=up,service=my-service")
+	actual := alertmanager.Config{}
+	header := http.Header{}
+	rwMock := ResponseWriterMock{
+		HeaderMock: func() http.Header {
+			return header
+		},
+		WriteMock: func(content []byte) (int, error) {
+			yaml.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/alertmanager/config", nil)
+
+	serve.AlertmanagerConfigHandler(rwMock, req)
+
+	s.Equal("text/yaml", header.Get("Content-Type"))
+	s.Equal("slack", actual.Route.Receiver)
+	s.Require().Len(actual.Route.Routes, 1)
+	s.Equal("jenkins-my-service-up", actual.Route.Routes[0].Receiver)
+	s.Equal(map[string]string{"scale": "up", "service": "my-service"}, actual.Route.Routes[0].Match)
+}
+
+func (s *ServerTestSuite) Test_AlertmanagerConfigHandler_ReturnsNotFound_WhenCatalogueIsNotConfigured() {
+	actualStatus := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actualStatus = status
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/alertmanager/config", nil)
+
+	serve := New()
+	serve.AlertmanagerConfigHandler(rwMock, req)
+
+	s.Equal(http.StatusNotFound, actualStatus)
+}
+
+// AlertmanagerUnroutedHandler
+
+func (s *ServerTestSuite) Test_AlertmanagerUnroutedHandler_ReturnsAlertsWithoutRoutes() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	s.writeReceivers(testReceivers)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&alertName.1=up&alertIf.1=a>b&alertLabels.1=scale=up,service=my-service&alertName.2=mem&alertIf.2=a>b&alertLabels.2=receiver=system")
+	actual := []alertmanager.UnroutedAlert{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/alertmanager/unrouted", nil)
+
+	serve.AlertmanagerUnroutedHandler(rwMock, req)
+
+	s.Equal([]alertmanager.UnroutedAlert{
+		{Alert: "myservice_mem", Service: "my-service", Labels: map[string]string{"receiver": "system"}},
+	}, actual)
+}
+
+func (s *ServerTestSuite) writeReceivers(config string) {
+	afero.WriteFile(FS, "/etc/dfm/test-receivers.yaml", []byte(config), 0644)
+	os.Setenv("DF_ALERTMANAGER_RECEIVERS", "/etc/dfm/test-receivers.yaml")
+	s.T().Cleanup(func() {
+		os.Unsetenv("DF_ALERTMANAGER_RECEIVERS")
+		FS.Remove("/etc/dfm/test-receivers.yaml")
+	})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"../prometheus"
+)
+
+// alertState is the state of an alert rule as evaluated by Prometheus.
+// Loaded is false when Prometheus has not loaded the rule yet.
+type alertState struct {
+	Alert          string                   `json:"alert"`
+	AlertName      string                   `json:"alertName"`
+	Loaded         bool                     `json:"loaded"`
+	State          string                   `json:"state,omitempty"`
+	Health         string                   `json:"health,omitempty"`
+	LastError      string                   `json:"lastError,omitempty"`
+	LastEvaluation *time.Time               `json:"lastEvaluation,omitempty"`
+	Active         []prometheus.ActiveAlert `json:"active"`
+}
+
+type alertStateResponse struct {
+	Status  int
+	Message string
+	Alerts  []alertState
+}
+
+// AlertStateHandler returns the state of the alert rules generated for the service
+// by joining the registered alerts with the rules and alerts APIs of Prometheus
+func (s *serve) AlertStateHandler(w http.ResponseWriter, req *http.Request) {
+	serviceName := req.URL.Query().Get("serviceName")
+	if len(serviceName) == 0 {
+		writeMessage(w, http.StatusBadRequest, "serviceName is not defined")
+		return
+	}
+	mu.Lock()
+	states := []alertState{}
+	for _, a := range s.alerts {
+		if a.ServiceName == serviceName {
+			states = append(states, alertState{Alert: a.AlertNameFormatted, AlertName: a.AlertName, Active: []prometheus.ActiveAlert{}})
+		}
+	}
+	mu.Unlock()
+	if len(states) == 0 {
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("Service %s has no alerts", serviceName))
+		return
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Alert < states[j].Alert
+	})
+
+	client := prometheus.NewAPIClient()
+	groups, err := client.Rules()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	active, err := client.Alerts()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rules := map[string]prometheus.Rule{}
+	for _, g := range groups {
+		for _, r := range g.Rules {
+			if r.Type == "alerting" {
+				rules[r.Name] = r
+			}
+		}
+	}
+	for i := range states {
+		if r, ok := rules[states[i].Alert]; ok {
+			lastEvaluation := r.LastEvaluation
+			states[i].Loaded = true
+			states[i].State = r.State
+			states[i].Health = r.Health
+			states[i].LastError = r.LastError
+			states[i].LastEvaluation = &lastEvaluation
+		}
+		for _, a := range active {
+			if a.Labels["alertname"] == states[i].Alert {
+				states[i].Active = append(states[i].Active, a)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, alertStateResponse{Status: http.StatusOK, Message: "OK", Alerts: states})
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+)
+
+func (s *ServerTestSuite) prometheusAPIServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/rules":
+			w.Write([]byte(`{"status":"success","data":{"groups":[{"name":"alert.rules","rules":[
+				{"name":"myservice_memlimit","type":"alerting","state":"firing","health":"ok","lastEvaluation":"2021-03-01T10:00:00Z"},
+				{"name":"myservice_errors","type":"alerting","state":"inactive","health":"err","lastError":"many-to-many matching not allowed"},
+				{"name":"myservice_memlimit","type":"recording","health":"ok"}]}]}}`))
+		case "/api/v1/alerts":
+			w.Write([]byte(`{"status":"success","data":{"alerts":[
+				{"labels":{"alertname":"myservice_memlimit","service":"my-service"},"state":"firing","value":"9.5e-01"},
+				{"labels":{"alertname":"otherservice_memlimit"},"state":"pending","value":"1e+00"}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// AlertStateHandler
+
+func (s *ServerTestSuite) Test_AlertStateHandler_ReturnsStateOfServiceRules() {
+	testServer := s.prometheusAPIServer()
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&alertName.1=memlimit&alertIf.1=a>b&alertName.2=errors&alertIf.2=c>d&alertName.3=latency&alertIf.3=e>f")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&alertName=memlimit&alertIf=a>b")
+	actual := alertStateResponse{}
+
+	status := s.serveJSON(serve.AlertStateHandler, "/v1/docker-flow-monitor/alerts/state?serviceName=my-service", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().Len(actual.Alerts, 3)
+	errors, latency, memlimit := actual.Alerts[0], actual.Alerts[1], actual.Alerts[2]
+	s.Equal("myservice_errors", errors.Alert)
+	s.True(errors.Loaded)
+	s.Equal("err", errors.Health)
+	s.Equal("many-to-many matching not allowed", errors.LastError)
+	s.Empty(errors.Active)
+	s.Equal("myservice_latency", latency.Alert)
+	s.False(latency.Loaded)
+	s.Empty(latency.State)
+	s.Equal("myservice_memlimit", memlimit.Alert)
+	s.Equal("memlimit", memlimit.AlertName)
+	s.Equal("firing", memlimit.State)
+	s.Equal("2021-03-01T10:00:00Z", memlimit.LastEvaluation.Format("2006-01-02T15:04:05Z07:00"))
+	s.Require().Len(memlimit.Active, 1)
+	s.Equal("9.5e-01", memlimit.Active[0].Value)
+}
+
+func (s *ServerTestSuite) Test_AlertStateHandler_ReturnsError_WhenRequestCannotBeServed() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Service Unavailable"))
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&alertName=memlimit&alertIf=a>b")
+	testData := []struct {
+		addr    string
+		status  int
+		message string
+	}{
+		{"/v1/docker-flow-monitor/alerts/state", http.StatusBadRequest, "serviceName is not defined"},
+		{"/v1/docker-flow-monitor/alerts/state?serviceName=unknown", http.StatusNotFound, "Service unknown has no alerts"},
+		{"/v1/docker-flow-monitor/alerts/state?serviceName=my-service", http.StatusInternalServerError, "prometheus responded to /api/v1/rules with 503: Service Unavailable"},
+	}
+
+	for _, data := range testData {
+		actual := alertStateResponse{}
+		status := s.serveJSON(serve.AlertStateHandler, data.addr, &actual)
+
+		s.Equal(data.status, status, data.addr)
+		s.Equal(data.message, actual.Message)
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../logging"
+	"github.com/spf13/afero"
+)
+
+var auditLimit = 1000
+var auditMaxSize int64 = 10 * 1024 * 1024
+var auditMaxFiles = 5
+var auditStdout io.Writer = os.Stdout
+
+// auditRecord describes a single state-changing request
+type auditRecord struct {
+	Timestamp      time.Time `json:"timestamp"`
+	Caller         string    `json:"caller"`
+	Action         string    `json:"action"`
+	Service        string    `json:"service,omitempty"`
+	Node           string    `json:"node,omitempty"`
+	AddedScrapes   []string  `json:"addedScrapes,omitempty"`
+	UpdatedScrapes []string  `json:"updatedScrapes,omitempty"`
+	RemovedScrapes []string  `json:"removedScrapes,omitempty"`
+	AddedAlerts    []string  `json:"addedAlerts,omitempty"`
+	UpdatedAlerts  []string  `json:"updatedAlerts,omitempty"`
+	RemovedAlerts  []string  `json:"removedAlerts,omitempty"`
+	Reload         string    `json:"reload,omitempty"`
+	ReloadError    string    `json:"reloadError,omitempty"`
+}
+
+// auditLog keeps the latest records in memory and writes every record as a JSON line
+// to stdout or to a file that is rotated once it grows over maxSize
+type auditLog struct {
+	mu       sync.Mutex
+	limit    int
+	records  []auditRecord
+	path     string
+	maxSize  int64
+	maxFiles int
+}
+
+type auditResponse struct {
+	Status  int
+	Message string
+	Records []auditRecord
+}
+
+func newAuditLog() *auditLog {
+	a := &auditLog{
+		limit:    auditLimit,
+		path:     os.Getenv("DF_AUDIT_LOG"),
+		maxSize:  auditMaxSize,
+		maxFiles: auditMaxFiles,
+	}
+	if l, err := strconv.Atoi(os.Getenv("DF_AUDIT_LIMIT")); err == nil && l > 0 {
+		a.limit = l
+	}
+	if size, err := strconv.ParseInt(os.Getenv("DF_AUDIT_LOG_MAX_SIZE"), 10, 64); err == nil && size > 0 {
+		a.maxSize = size
+	}
+	if files, err := strconv.Atoi(os.Getenv("DF_AUDIT_LOG_MAX_FILES")); err == nil && files > 0 {
+		a.maxFiles = files
+	}
+	return a
+}
+
+func (a *auditLog) add(record auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, record)
+	if len(a.records) > a.limit {
+		a.records = a.records[len(a.records)-a.limit:]
+	}
+	if len(a.path) == 0 {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Error("Unable to encode audit record", logging.Fields{"error": err})
+		return
+	}
+	line = append(line, '\n')
+	if a.path == "stdout" {
+		auditStdout.Write(line)
+		return
+	}
+	if err := a.write(line); err != nil {
+		logger.Error("Unable to write audit record", logging.Fields{"path": a.path, "error": err})
+	}
+}
+
+func (a *auditLog) write(line []byte) error {
+	if info, err := FS.Stat(a.path); err == nil && info.Size()+int64(len(line)) > a.maxSize {
+		a.rotate()
+	}
+	f, err := FS.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return err
+}
+
+// rotate renames path to path.1, path.1 to path.2 and so on, discarding the oldest file
+func (a *auditLog) rotate() {
+	FS.Remove(fmt.Sprintf("%s.%d", a.path, a.maxFiles))
+	for i := a.maxFiles - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", a.path, i)
+		if exists, _ := afero.Exists(FS, from); exists {
+			FS.Rename(from, fmt.Sprintf("%s.%d", a.path, i+1))
+		}
+	}
+	FS.Rename(a.path, a.path+".1")
+}
+
+// query returns records filtered by service and action, newest last
+func (a *auditLog) query(service, action string, since time.Time, limit int) []auditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	records := []auditRecord{}
+	for _, r := range a.records {
+		if len(service) > 0 && r.Service != service {
+			continue
+		}
+		if len(action) > 0 && r.Action != action {
+			continue
+		}
+		if r.Timestamp.Before(since) {
+			continue
+		}
+		records = append(records, r)
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+// audit records the changes made since before by a request
+func (s *serve) audit(req *http.Request, action, service, node string, before serveState, reloadErr error) {
+	change := s.changesSince(before)
+	record := newAuditRecord(getCaller(req), action, service, change)
+	record.Node = node
+	record.Reload = "succeeded"
+	if reloadErr != nil {
+		record.Reload = "failed"
+		record.ReloadError = reloadErr.Error()
+	}
+	s.auditLog.add(record)
+}
+
+// auditImport records services imported from caller since before, one record per service
+func (s *serve) auditImport(caller string, before serveState) {
+	change := s.changesSince(before)
+	services := []string{}
+	changes := map[string]*stateChange{}
+	get := func(service string) *stateChange {
+		if _, ok := changes[service]; !ok {
+			services = append(services, service)
+			changes[service] = &stateChange{}
+		}
+		return changes[service]
+	}
+	for _, name := range change.AddedScrapes {
+		c := get(s.scrapes[name].ServiceName)
+		c.AddedScrapes = append(c.AddedScrapes, name)
+	}
+	for _, name := range change.UpdatedScrapes {
+		c := get(s.scrapes[name].ServiceName)
+		c.UpdatedScrapes = append(c.UpdatedScrapes, name)
+	}
+	for _, name := range change.AddedAlerts {
+		c := get(s.alerts[name].ServiceName)
+		c.AddedAlerts = append(c.AddedAlerts, name)
+	}
+	for _, name := range change.UpdatedAlerts {
+		c := get(s.alerts[name].ServiceName)
+		c.UpdatedAlerts = append(c.UpdatedAlerts, name)
+	}
+	for _, service := range services {
+		s.auditLog.add(newAuditRecord(caller, "import", service, *changes[service]))
+	}
+	for _, node := range append(change.AddedNodes, change.UpdatedNodes...) {
+		record := newAuditRecord(caller, "import", "", stateChange{})
+		record.Node = node
+		s.auditLog.add(record)
+	}
+}
+
+func newAuditRecord(caller, action, service string, change stateChange) auditRecord {
+	return auditRecord{
+		Timestamp:      time.Now().UTC(),
+		Caller:         caller,
+		Action:         action,
+		Service:        service,
+		AddedScrapes:   change.AddedScrapes,
+		UpdatedScrapes: change.UpdatedScrapes,
+		RemovedScrapes: change.RemovedScrapes,
+		AddedAlerts:    change.AddedAlerts,
+		UpdatedAlerts:  change.UpdatedAlerts,
+		RemovedAlerts:  change.RemovedAlerts,
+	}
+}
+
+// getCaller returns the address of the client that sent the request
+func getCaller(req *http.Request) string {
+	if forwarded := req.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return req.RemoteAddr
+}
+
+func (s *serve) AuditHandler(w http.ResponseWriter, req *http.Request) {
+	resp := auditResponse{Status: http.StatusOK}
+	query := req.URL.Query()
+	since := time.Time{}
+	limit := 0
+	var err error
+	if len(query.Get("since")) > 0 {
+		if since, err = time.Parse(time.RFC3339, query.Get("since")); err != nil {
+			err = fmt.Errorf("since query parameter must be in RFC3339 format")
+		}
+	}
+	if err == nil && len(query.Get("limit")) > 0 {
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil {
+			err = fmt.Errorf("limit query parameter must be a number")
+		}
+	}
+	if err != nil {
+		resp.Status = http.StatusBadRequest
+		resp.Message = err.Error()
+	} else {
+		resp.Records = s.auditLog.query(query.Get("serviceName"), query.Get("action"), since, limit)
+	}
+	writeJSON(w, resp.Status, resp)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"../prometheus"
+	"github.com/spf13/afero"
+)
+
+// Audit
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsAuditRecord() {
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if", nil)
+	req.RemoteAddr = "10.0.0.1:5555"
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	s.Require().Len(serve.auditLog.records, 1)
+	record := serve.auditLog.records[0]
+	s.Equal("10.0.0.1:5555", record.Caller)
+	s.Equal("reconfigure", record.Action)
+	s.Equal("my-service", record.Service)
+	s.Equal([]string{"my-service"}, record.AddedScrapes)
+	s.Equal([]string{"myservice_myalert"}, record.AddedAlerts)
+	s.Equal("succeeded", record.Reload)
+	s.False(record.Timestamp.IsZero())
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsAuditRecordWithUpdates() {
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=5678&alertName.1=other-alert&alertIf.1=other-if")
+
+	s.Require().Len(serve.auditLog.records, 2)
+	record := serve.auditLog.records[1]
+	s.Equal([]string{"my-service"}, record.UpdatedScrapes)
+	s.Equal([]string{"myservice_otheralert"}, record.AddedAlerts)
+	s.Equal([]string{"myservice_myalert"}, record.RemovedAlerts)
+}
+
+func (s *ServerTestSuite) Test_RemoveHandler_AddsAuditRecord() {
+	prometheus.Reload = func() error {
+		return errors.New("Prometheus error")
+	}
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-monitor/remove?serviceName=my-service", nil)
+	req.Header.Set("X-Forwarded-For", "10.0.0.2, 10.0.0.3")
+
+	serve := New()
+	serve.scrapes["my-service"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 1234}
+	serve.alerts["myservice_myalert"] = prometheus.Alert{ServiceName: "my-service", AlertName: "myalert"}
+	serve.RemoveHandler(ResponseWriterMock{}, req)
+
+	s.Require().Len(serve.auditLog.records, 1)
+	record := serve.auditLog.records[0]
+	s.Equal("10.0.0.2", record.Caller)
+	s.Equal("remove", record.Action)
+	s.Equal([]string{"my-service"}, record.RemovedScrapes)
+	s.Equal([]string{"myservice_myalert"}, record.RemovedAlerts)
+	s.Equal("failed", record.Reload)
+	s.Equal("Prometheus error", record.ReloadError)
+}
+
+func (s *ServerTestSuite) Test_NodeHandlers_AddAuditRecords() {
+	serve := New()
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/node/reconfigure?id=node1id", nil)
+	serve.ReconfigureNodeHandler(ResponseWriterMock{}, req)
+	req, _ = http.NewRequest("DELETE", "/v1/docker-flow-monitor/node/remove?id=node1id", nil)
+	serve.RemoveNodeHandler(ResponseWriterMock{}, req)
+
+	s.Require().Len(serve.auditLog.records, 2)
+	s.Equal("node-reconfigure", serve.auditLog.records[0].Action)
+	s.Equal("node1id", serve.auditLog.records[0].Node)
+	s.Equal("node-remove", serve.auditLog.records[1].Action)
+	s.Equal("node1id", serve.auditLog.records[1].Node)
+}
+
+func (s *ServerTestSuite) Test_InitialConfig_AddsAuditRecords() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		resp := []map[string]string{
+			{"scrapePort": "1111", "serviceName": "service-1", "alertName": "my-alert", "alertIf": "my-if"},
+			{"scrapePort": "2222", "serviceName": "service-2"},
+		}
+		js, _ := json.Marshal(resp)
+		w.Write(js)
+	}))
+	defer testServer.Close()
+	defer func() { os.Unsetenv("LISTENER_ADDRESS") }()
+	os.Setenv("LISTENER_ADDRESS", testServer.URL)
+
+	serve := New()
+	serve.InitialConfig()
+
+	s.Require().Len(serve.auditLog.records, 2)
+	s.Equal("import", serve.auditLog.records[0].Action)
+	s.Equal(testServer.URL+"/v1/docker-flow-swarm-listener/get-services", serve.auditLog.records[0].Caller)
+	s.Equal("service-1", serve.auditLog.records[0].Service)
+	s.Equal([]string{"service-1"}, serve.auditLog.records[0].AddedScrapes)
+	s.Equal([]string{"service1_myalert"}, serve.auditLog.records[0].AddedAlerts)
+	s.Equal("service-2", serve.auditLog.records[1].Service)
+}
+
+func (s *ServerTestSuite) Test_AuditLog_WritesJSONLinesToStdout() {
+	stdoutOrig := auditStdout
+	defer func() {
+		auditStdout = stdoutOrig
+		os.Unsetenv("DF_AUDIT_LOG")
+	}()
+	actual := &bytes.Buffer{}
+	auditStdout = actual
+	os.Setenv("DF_AUDIT_LOG", "stdout")
+
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+
+	record := auditRecord{}
+	s.Require().NoError(json.Unmarshal(actual.Bytes(), &record))
+	s.Equal("my-service", record.Service)
+	s.True(strings.HasSuffix(actual.String(), "}\n"))
+}
+
+func (s *ServerTestSuite) Test_AuditLog_RotatesFile() {
+	fsOrig := FS
+	defer func() { FS = fsOrig }()
+	FS = afero.NewMemMapFs()
+	a := &auditLog{limit: 10, path: "/var/log/dfm/audit.log", maxSize: 100, maxFiles: 2}
+
+	for i := 0; i < 8; i++ {
+		a.add(auditRecord{Action: "reconfigure", Service: fmt.Sprintf("service-%d", i)})
+	}
+
+	for _, path := range []string{"/var/log/dfm/audit.log", "/var/log/dfm/audit.log.1", "/var/log/dfm/audit.log.2"} {
+		info, err := FS.Stat(path)
+		s.Require().NoError(err)
+		s.True(info.Size() <= 100)
+	}
+	exists, _ := afero.Exists(FS, "/var/log/dfm/audit.log.3")
+	s.False(exists)
+	content, _ := afero.ReadFile(FS, "/var/log/dfm/audit.log")
+	s.Contains(string(content), "service-7")
+}
+
+// AuditHandler
+
+func (s *ServerTestSuite) Test_AuditHandler_ReturnsFilteredRecords() {
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=service-1&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=service-2&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=service-1&scrapePort=5678")
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-monitor/remove?serviceName=service-1", nil)
+	serve.RemoveHandler(ResponseWriterMock{}, req)
+
+	actual := auditResponse{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ = http.NewRequest("GET", "/v1/docker-flow-monitor/audit?serviceName=service-1&action=reconfigure&limit=1", nil)
+	serve.AuditHandler(rwMock, req)
+
+	s.Equal(http.StatusOK, actual.Status)
+	s.Require().Len(actual.Records, 1)
+	s.Equal("service-1", actual.Records[0].Service)
+	s.Equal([]string{"service-1"}, actual.Records[0].UpdatedScrapes)
+}
+
+func (s *ServerTestSuite) Test_AuditHandler_ReturnsBadRequest_WhenSinceIsInvalid() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/audit?since=yesterday", nil)
+
+	serve := New()
+	serve.AuditHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual)
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+
+	"../prometheus"
+)
+
+// TargetsHandler serves the target groups of the job defined with the job query parameter
+// in the Prometheus HTTP service discovery format. Unknown jobs have no target groups.
+func (s *serve) TargetsHandler(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+	targetGroups := prometheus.FileStaticConfig{}
+	if scrape, ok := s.scrapes[req.URL.Query().Get("job")]; ok {
+		targetGroups = prometheus.GetTargetGroups(scrape, s.nodeLabels)
+	}
+	writeJSON(w, http.StatusOK, targetGroups)
+}
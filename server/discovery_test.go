@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"../prometheus"
+)
+
+// TargetsHandler
+
+func (s *ServerTestSuite) Test_TargetsHandler_ReturnsTargetGroups() {
+	nodeInfo := prometheus.NodeIPSet{}
+	nodeInfo.Add("node-2", "1.0.0.2", "id2")
+	nodeInfo.Add("node-1", "1.0.0.1", "id1")
+	serve := New()
+	serve.scrapes["my-service_1"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 9404, NodeInfo: nodeInfo, ScrapeIndex: 1}
+	serve.nodeLabels["id1"] = map[string]string{"aws_region": "us-east"}
+	actual := prometheus.FileStaticConfig{}
+	header := http.Header{}
+	rwMock := ResponseWriterMock{
+		HeaderMock: func() http.Header {
+			return header
+		},
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/targets?job=my-service_1", nil)
+
+	serve.TargetsHandler(rwMock, req)
+
+	s.Equal(prometheus.FileStaticConfig{
+		{Targets: []string{"1.0.0.1:9404"}, Labels: map[string]string{"node": "node-1", "service": "my-service", "aws_region": "us-east"}},
+		{Targets: []string{"1.0.0.2:9404"}, Labels: map[string]string{"node": "node-2", "service": "my-service"}},
+	}, actual)
+	s.Equal("application/json", header.Get("Content-Type"))
+}
+
+func (s *ServerTestSuite) Test_TargetsHandler_ReturnsEmptyList_WhenJobDoesNotExist() {
+	actual := ""
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			actual = string(content)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/targets?job=my-service", nil)
+
+	serve := New()
+	serve.TargetsHandler(rwMock, req)
+
+	s.Equal("[]", actual)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"../logging"
+)
+
+var eventsBufferSize = 100
+var eventsKeepAlive = 30 * time.Second
+
+// event describes a single change of the monitoring configuration
+type event struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Service   string    `json:"service,omitempty"`
+	Alert     string    `json:"alert,omitempty"`
+	Node      string    `json:"node,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// eventBroker fans events out to all subscribers.
+// Publishing never blocks; a subscriber that does not keep up loses events.
+type eventBroker struct {
+	mu          sync.Mutex
+	next        int
+	subscribers map[chan event]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{next: 1, subscribers: map[chan event]struct{}{}}
+}
+
+func (b *eventBroker) subscribe() chan event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan event, eventsBufferSize)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *eventBroker) publish(e event) event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.ID = b.next
+	b.next++
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logger.Warn("Dropping event for a slow subscriber", logging.Fields{"event": e.Type, "id": e.ID})
+		}
+	}
+	return e
+}
+
+// publishChanges emits an event for each scrape, alert and node changed since before,
+// followed by the result of the Prometheus reload, and notifies webhooks about them
+func (s *serve) publishChanges(before serveState, reloadErr error) {
+	change := s.changesSince(before)
+	events := []event{}
+	publish := func(e event) {
+		events = append(events, s.events.publish(e))
+	}
+	for _, name := range change.AddedScrapes {
+		publish(event{Type: "scrape-added", Service: s.scrapes[name].ServiceName})
+	}
+	for _, name := range change.UpdatedScrapes {
+		publish(event{Type: "scrape-updated", Service: s.scrapes[name].ServiceName})
+	}
+	for _, name := range change.RemovedScrapes {
+		publish(event{Type: "scrape-removed", Service: before.scrapes[name].ServiceName})
+	}
+	for _, name := range change.AddedAlerts {
+		publish(event{Type: "alert-added", Service: s.alerts[name].ServiceName, Alert: name})
+	}
+	for _, name := range change.UpdatedAlerts {
+		publish(event{Type: "alert-updated", Service: s.alerts[name].ServiceName, Alert: name})
+	}
+	for _, name := range change.RemovedAlerts {
+		publish(event{Type: "alert-removed", Service: before.alerts[name].ServiceName, Alert: name})
+	}
+	for _, node := range change.AddedNodes {
+		publish(event{Type: "node-added", Node: node})
+	}
+	for _, node := range change.UpdatedNodes {
+		publish(event{Type: "node-updated", Node: node})
+	}
+	for _, node := range change.RemovedNodes {
+		publish(event{Type: "node-removed", Node: node})
+	}
+	if reloadErr != nil {
+		publish(event{Type: "reload-failed", Error: reloadErr.Error()})
+	} else {
+		publish(event{Type: "reload-succeeded"})
+	}
+	s.webhooks.notify(events, reloadErr)
+}
+
+// EventsHandler streams events as server-sent events until the client disconnects.
+// The serviceName query parameter limits the stream to the events of a service
+// and the reload results.
+func (s *serve) EventsHandler(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, response{Status: http.StatusInternalServerError, Message: "Streaming is not supported"})
+		return
+	}
+	serviceName := req.URL.Query().Get("serviceName")
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case e := <-ch:
+			if len(serviceName) > 0 && len(e.Service) > 0 && e.Service != serviceName {
+				continue
+			}
+			if len(serviceName) > 0 && len(e.Node) > 0 {
+				continue
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"../prometheus"
+)
+
+// publishChanges
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_PublishesEvents() {
+	serve := New()
+	ch := serve.events.subscribe()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if")
+
+	actual := s.receiveEvents(ch, 3)
+
+	s.Equal("scrape-added", actual[0].Type)
+	s.Equal("my-service", actual[0].Service)
+	s.Equal("alert-added", actual[1].Type)
+	s.Equal("my-service", actual[1].Service)
+	s.Equal("myservice_myalert", actual[1].Alert)
+	s.Equal("reload-succeeded", actual[2].Type)
+	s.Equal([]int{1, 2, 3}, []int{actual[0].ID, actual[1].ID, actual[2].ID})
+}
+
+func (s *ServerTestSuite) Test_RemoveHandler_PublishesEvents() {
+	prometheus.Reload = func() error {
+		return errors.New("Prometheus error")
+	}
+	serve := New()
+	serve.scrapes["my-service"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 1234}
+	serve.alerts["myservice_myalert"] = prometheus.Alert{ServiceName: "my-service", AlertName: "myalert"}
+	ch := serve.events.subscribe()
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-monitor/remove?serviceName=my-service", nil)
+	serve.RemoveHandler(ResponseWriterMock{}, req)
+
+	actual := s.receiveEvents(ch, 3)
+
+	s.Equal("scrape-removed", actual[0].Type)
+	s.Equal("my-service", actual[0].Service)
+	s.Equal("alert-removed", actual[1].Type)
+	s.Equal("my-service", actual[1].Service)
+	s.Equal("reload-failed", actual[2].Type)
+	s.Equal("Prometheus error", actual[2].Error)
+}
+
+func (s *ServerTestSuite) Test_NodeHandlers_PublishEvents() {
+	serve := New()
+	ch := serve.events.subscribe()
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/node/reconfigure?id=node1id", nil)
+	serve.ReconfigureNodeHandler(ResponseWriterMock{}, req)
+	req, _ = http.NewRequest("DELETE", "/v1/docker-flow-monitor/node/remove?id=node1id", nil)
+	serve.RemoveNodeHandler(ResponseWriterMock{}, req)
+
+	actual := s.receiveEvents(ch, 4)
+
+	s.Equal("node-added", actual[0].Type)
+	s.Equal("node1id", actual[0].Node)
+	s.Equal("reload-succeeded", actual[1].Type)
+	s.Equal("node-removed", actual[2].Type)
+	s.Equal("node1id", actual[2].Node)
+	s.Equal("reload-succeeded", actual[3].Type)
+}
+
+func (s *ServerTestSuite) Test_EventBroker_DropsEvents_WhenSubscriberIsSlow() {
+	sizeOrig := eventsBufferSize
+	defer func() { eventsBufferSize = sizeOrig }()
+	eventsBufferSize = 1
+	b := newEventBroker()
+	ch := b.subscribe()
+
+	b.publish(event{Type: "reload-succeeded"})
+	b.publish(event{Type: "reload-failed"})
+
+	s.Len(ch, 1)
+	s.Equal("reload-succeeded", (<-ch).Type)
+}
+
+// EventsHandler
+
+func (s *ServerTestSuite) Test_EventsHandler_StreamsServerSentEvents() {
+	serve := New()
+	testServer := httptest.NewServer(http.HandlerFunc(serve.EventsHandler))
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/v1/docker-flow-monitor/events?serviceName=my-service")
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+
+	reader := bufio.NewReader(resp.Body)
+	messages := []string{}
+	for len(messages) < 3 {
+		message := ""
+		for {
+			line, err := reader.ReadString('\n')
+			s.Require().NoError(err)
+			if line == "\n" {
+				break
+			}
+			message += line
+		}
+		messages = append(messages, message)
+	}
+
+	s.Equal("id: 2\nevent: reload-succeeded\n", messages[0][:strings.Index(messages[0], "data: ")])
+	s.Equal("id: 3\nevent: scrape-added\n", messages[1][:strings.Index(messages[1], "data: ")])
+	e := event{}
+	json.Unmarshal([]byte(strings.TrimPrefix(strings.Split(messages[1], "\n")[2], "data: ")), &e)
+	s.Equal("my-service", e.Service)
+	s.Equal("id: 4\nevent: reload-succeeded\n", messages[2][:strings.Index(messages[2], "data: ")])
+}
+
+func (s *ServerTestSuite) Test_EventsHandler_ReturnsError_WhenStreamingIsNotSupported() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/events", nil)
+
+	serve := New()
+	serve.EventsHandler(rwMock, req)
+
+	s.Equal(http.StatusInternalServerError, actual)
+}
+
+// Util
+
+func (s *ServerTestSuite) receiveEvents(ch chan event, count int) []event {
+	events := []event{}
+	for len(events) < count {
+		select {
+		case e := <-ch:
+			events = append(events, e)
+		case <-time.After(time.Second):
+			s.FailNow("Timed out waiting for events")
+		}
+	}
+	return events
+}
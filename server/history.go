@@ -0,0 +1,395 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../logging"
+	"../prometheus"
+	"github.com/spf13/afero"
+)
+
+var configHistoryLimit = 20
+
+type configHistory struct {
+	limit    int
+	next     int
+	versions []*configVersion
+}
+
+type configVersion struct {
+	Version   int
+	Timestamp time.Time
+	Request   string
+	Files     map[string]string
+	state     serveState
+}
+
+type versionResponse struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Request   string    `json:"request"`
+	Files     []string  `json:"files"`
+}
+
+type diffResponse struct {
+	Status  int
+	Message string
+	From    int
+	To      int
+	Diff    map[string]string
+}
+
+type rollbackResponse struct {
+	Status  int
+	Message string
+	Version int
+}
+
+func newConfigHistory() *configHistory {
+	limit := configHistoryLimit
+	if l, err := strconv.Atoi(os.Getenv("DF_CONFIG_HISTORY_LIMIT")); err == nil && l > 0 {
+		limit = l
+	}
+	return &configHistory{limit: limit, next: 1}
+}
+
+// record stores the generated files and the state that produced them.
+// Nothing is stored when the files did not change since the latest version.
+func (h *configHistory) record(request string, s *serve) *configVersion {
+	files := s.readGeneratedFiles()
+	if latest := h.latest(); latest != nil && equalFiles(latest.Files, files) {
+		return nil
+	}
+	v := &configVersion{
+		Version:   h.next,
+		Timestamp: time.Now().UTC(),
+		Request:   request,
+		Files:     files,
+		state:     s.snapshot(),
+	}
+	h.next++
+	h.versions = append(h.versions, v)
+	if len(h.versions) > h.limit {
+		h.versions = h.versions[len(h.versions)-h.limit:]
+	}
+	return v
+}
+
+func (h *configHistory) latest() *configVersion {
+	if len(h.versions) == 0 {
+		return nil
+	}
+	return h.versions[len(h.versions)-1]
+}
+
+func (h *configHistory) get(version int) (*configVersion, error) {
+	for _, v := range h.versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("Version %d is not in the history", version)
+}
+
+func (s *serve) readGeneratedFiles() map[string]string {
+	files := map[string]string{}
+	for _, path := range prometheus.GeneratedFiles(s.configPath) {
+		if content, err := afero.ReadFile(prometheus.FS, path); err == nil {
+			files[path] = string(content)
+		}
+	}
+	return files
+}
+
+// recordConfig adds the currently generated files to the history
+func (s *serve) recordConfig(req *http.Request) {
+	request := "startup"
+	if req != nil {
+		request = fmt.Sprintf("%s %s", req.Method, req.URL.String())
+	}
+	if v := s.history.record(request, s); v != nil {
+		logger.Debug("Recorded configuration version", logging.Fields{"version": v.Version})
+	}
+}
+
+func (s *serve) ConfigVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+	versions := []versionResponse{}
+	for _, v := range s.history.versions {
+		files := []string{}
+		for path := range v.Files {
+			files = append(files, path)
+		}
+		sort.Strings(files)
+		versions = append(versions, versionResponse{
+			Version:   v.Version,
+			Timestamp: v.Timestamp,
+			Request:   v.Request,
+			Files:     files,
+		})
+	}
+	writeJSON(w, http.StatusOK, versions)
+}
+
+func (s *serve) ConfigDiffHandler(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+	resp := diffResponse{Status: http.StatusOK}
+	from, to, err := s.getDiffVersions(req)
+	if err != nil {
+		resp.Status = http.StatusBadRequest
+		resp.Message = err.Error()
+	} else {
+		resp.From = from.Version
+		resp.To = to.Version
+		resp.Diff = diffFiles(from.Files, to.Files)
+	}
+	writeJSON(w, resp.Status, resp)
+}
+
+// ConfigRollbackHandler restores the state of a version and regenerates the files from it.
+// The files are not copied from the history so that the targets of file based service discovery
+// are regenerated as well.
+func (s *serve) ConfigRollbackHandler(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+	logRequest(req)
+	resp := rollbackResponse{Status: http.StatusOK}
+	version, err := strconv.Atoi(req.URL.Query().Get("version"))
+	var v *configVersion
+	if err != nil {
+		err = fmt.Errorf("version query parameter must be a number")
+	} else {
+		v, err = s.history.get(version)
+	}
+	if err != nil {
+		resp.Status = http.StatusBadRequest
+		resp.Message = err.Error()
+	} else {
+		resp.Version = v.Version
+		before := s.snapshot()
+		s.restore(v.state)
+		prometheus.WriteConfig(s.configPath, s.scrapes, s.alerts, s.nodeLabels)
+		s.recordConfig(req)
+		err := prometheus.Reload()
+		s.audit(req, "rollback", "", "", before, err)
+		s.publishChanges(before, err)
+		if err != nil {
+			resp.Status = http.StatusInternalServerError
+			resp.Message = err.Error()
+		}
+	}
+	writeJSON(w, resp.Status, resp)
+}
+
+// getDiffVersions returns the versions defined with the from and to query parameters.
+// When omitted, to defaults to the latest version and from to the one before it.
+func (s *serve) getDiffVersions(req *http.Request) (*configVersion, *configVersion, error) {
+	latest := s.history.latest()
+	if latest == nil {
+		return nil, nil, fmt.Errorf("There are no versions in the history")
+	}
+	toVersion := latest.Version
+	if len(req.URL.Query().Get("to")) > 0 {
+		v, err := strconv.Atoi(req.URL.Query().Get("to"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("to query parameter must be a number")
+		}
+		toVersion = v
+	}
+	fromVersion := toVersion - 1
+	if len(req.URL.Query().Get("from")) > 0 {
+		v, err := strconv.Atoi(req.URL.Query().Get("from"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("from query parameter must be a number")
+		}
+		fromVersion = v
+	}
+	to, err := s.history.get(toVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	from, err := s.history.get(fromVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	return from, to, nil
+}
+
+func equalFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, content := range a {
+		if other, ok := b[path]; !ok || other != content {
+			return false
+		}
+	}
+	return true
+}
+
+// diffFiles returns a unified diff for each file that differs between from and to
+func diffFiles(from, to map[string]string) map[string]string {
+	paths := map[string]struct{}{}
+	for path := range from {
+		paths[path] = struct{}{}
+	}
+	for path := range to {
+		paths[path] = struct{}{}
+	}
+	diffs := map[string]string{}
+	for path := range paths {
+		if from[path] == to[path] {
+			continue
+		}
+		diffs[path] = unifiedDiff(path, from[path], to[path])
+	}
+	return diffs
+}
+
+const diffContext = 3
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the difference between two texts in the unified diff format
+func unifiedDiff(name, a, b string) string {
+	lines := diffLines(splitLines(a), splitLines(b))
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", name, name)
+
+	aLine, bLine := 1, 1
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		// Find the end of the hunk, merging changes separated by less than two contexts
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end += diffContext
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				aCount++
+			}
+			if l.op != '-' {
+				bCount++
+			}
+		}
+		aLine, bLine = aStart+aCount, bStart+bCount
+		// Empty ranges start at the line before the change
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, l := range lines[start:end] {
+			fmt.Fprintf(out, "%c%s\n", l.op, l.text)
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func splitLines(text string) []string {
+	if len(text) == 0 {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes the longest common subsequence of a and b after trimming the
+// common prefix and suffix, which keeps the table small for configuration files
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	for _, l := range a[:prefix] {
+		lines = append(lines, diffLine{' ', l})
+	}
+	i, j := 0, 0
+	for i < len(am) && j < len(bm) {
+		if am[i] == bm[j] {
+			lines = append(lines, diffLine{' ', am[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, diffLine{'-', am[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{'+', bm[j]})
+			j++
+		}
+	}
+	for ; i < len(am); i++ {
+		lines = append(lines, diffLine{'-', am[i]})
+	}
+	for ; j < len(bm); j++ {
+		lines = append(lines, diffLine{'+', bm[j]})
+	}
+	for _, l := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', l})
+	}
+	return lines
+}
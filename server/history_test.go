@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+
+	"../prometheus"
+	"github.com/spf13/afero"
+)
+
+// ConfigVersionsHandler
+
+func (s *ServerTestSuite) Test_ConfigVersionsHandler_ReturnsVersions() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=5678&alertName=my-alert&alertIf=my-if")
+
+	actual := []versionResponse{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/config/versions", nil)
+	serve.ConfigVersionsHandler(rwMock, req)
+
+	s.Require().Len(actual, 2)
+	s.Equal(1, actual[0].Version)
+	s.Equal("GET /v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234", actual[0].Request)
+	s.Equal([]string{"/etc/prometheus/prometheus.yml"}, actual[0].Files)
+	s.Equal(2, actual[1].Version)
+	s.Equal([]string{"/etc/prometheus/alert.rules", "/etc/prometheus/prometheus.yml"}, actual[1].Files)
+}
+
+func (s *ServerTestSuite) Test_ConfigVersionsHandler_RecordsServiceFiles_WhenLayoutIsPerService() {
+	fsOrig := prometheus.FS
+	defer func() {
+		prometheus.FS = fsOrig
+		os.Unsetenv("DF_CONFIG_LAYOUT")
+	}()
+	prometheus.FS = afero.NewMemMapFs()
+	os.Setenv("DF_CONFIG_LAYOUT", "per-service")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if")
+
+	s.Require().Len(serve.history.versions, 1)
+	actual := []string{}
+	for path := range serve.history.versions[0].Files {
+		actual = append(actual, path)
+	}
+	s.ElementsMatch([]string{
+		"/etc/prometheus/prometheus.yml",
+		"/etc/prometheus/scrape_configs/my-service.yml",
+		"/etc/prometheus/rules/my-service.rules",
+	}, actual)
+}
+
+func (s *ServerTestSuite) Test_ConfigVersionsHandler_DoesNotRecordUnchangedConfig() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	serve := New()
+
+	for i := 0; i < 3; i++ {
+		s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	}
+
+	s.Len(serve.history.versions, 1)
+}
+
+func (s *ServerTestSuite) Test_ConfigVersionsHandler_KeepsLimitedNumberOfVersions() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	serve := New()
+	serve.history.limit = 2
+
+	for _, port := range []string{"1111", "2222", "3333"} {
+		s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort="+port)
+	}
+
+	s.Require().Len(serve.history.versions, 2)
+	s.Equal(2, serve.history.versions[0].Version)
+	s.Equal(3, serve.history.versions[1].Version)
+}
+
+// ConfigDiffHandler
+
+func (s *ServerTestSuite) Test_ConfigDiffHandler_ReturnsUnifiedDiff() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=5678")
+
+	actual := diffResponse{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/config/diff?from=1&to=2", nil)
+	serve.ConfigDiffHandler(rwMock, req)
+
+	s.Equal(http.StatusOK, actual.Status)
+	s.Equal(1, actual.From)
+	s.Equal(2, actual.To)
+	s.Require().Len(actual.Diff, 1)
+	diff := actual.Diff["/etc/prometheus/prometheus.yml"]
+	s.True(strings.HasPrefix(diff, "--- /etc/prometheus/prometheus.yml\n+++ /etc/prometheus/prometheus.yml\n@@ "))
+	s.Contains(diff, "\n-    port: 1234\n+    port: 5678\n")
+}
+
+func (s *ServerTestSuite) Test_ConfigDiffHandler_ReturnsBadRequest_WhenVersionDoesNotExist() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/config/diff?from=1&to=2", nil)
+
+	serve := New()
+	serve.ConfigDiffHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual)
+}
+
+func (s *ServerTestSuite) Test_UnifiedDiff_ReturnsHunks() {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n15\n16\n"
+	expected := `--- file
++++ file
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -11,5 +11,5 @@
+ 11
+ 12
+ 13
+-14
+ 15
++16
+`
+
+	s.Equal(expected, unifiedDiff("file", a, b))
+}
+
+// ConfigRollbackHandler
+
+func (s *ServerTestSuite) Test_ConfigRollbackHandler_RestoresFilesAndState() {
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if")
+	expectedConfig, _ := afero.ReadFile(prometheus.FS, "/etc/prometheus/prometheus.yml")
+	expectedAlerts, _ := afero.ReadFile(prometheus.FS, "/etc/prometheus/alert.rules")
+	expectedScrapes := copyScrapes(serve.scrapes)
+	expectedAlertsState := copyAlerts(serve.alerts)
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=5678&alertName=my-alert&alertIf=other-if")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=9999")
+	s.reloadCalledNum = 0
+
+	actual := rollbackResponse{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-monitor/config/rollback?version=1", nil)
+	serve.ConfigRollbackHandler(rwMock, req)
+
+	s.Equal(http.StatusOK, actual.Status)
+	s.Equal(1, actual.Version)
+	s.Equal(expectedScrapes, serve.scrapes)
+	s.Equal(expectedAlertsState, serve.alerts)
+	actualConfig, _ := afero.ReadFile(prometheus.FS, "/etc/prometheus/prometheus.yml")
+	actualAlerts, _ := afero.ReadFile(prometheus.FS, "/etc/prometheus/alert.rules")
+	s.Equal(string(expectedConfig), string(actualConfig))
+	s.Equal(string(expectedAlerts), string(actualAlerts))
+	s.Equal(1, s.reloadCalledNum)
+	s.Require().Len(serve.history.versions, 4)
+	s.Equal("PUT /v1/docker-flow-monitor/config/rollback?version=1", serve.history.latest().Request)
+}
+
+func (s *ServerTestSuite) Test_ConfigRollbackHandler_RestoresServiceFiles_WhenLayoutIsPerService() {
+	fsOrig := prometheus.FS
+	defer func() {
+		prometheus.FS = fsOrig
+		os.Unsetenv("DF_CONFIG_LAYOUT")
+	}()
+	prometheus.FS = afero.NewMemMapFs()
+	os.Setenv("DF_CONFIG_LAYOUT", "per-service")
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if")
+	expected := serve.history.latest().Files
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=5678&alertName=my-alert&alertIf=other-if")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=9999&alertName=my-alert&alertIf=my-if")
+
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-monitor/config/rollback?version=1", nil)
+	serve.ConfigRollbackHandler(ResponseWriterMock{}, req)
+
+	s.Equal(expected, serve.readGeneratedFiles())
+}
+
+func (s *ServerTestSuite) Test_ConfigRollbackHandler_ReturnsBadRequest_WhenVersionIsNotInHistory() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-monitor/config/rollback?version=42", nil)
+
+	serve := New()
+	serve.ConfigRollbackHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual)
+	s.Equal(0, s.reloadCalledNum)
+}
+
+// Util
+
+func (s *ServerTestSuite) reconfigure(serve *serve, addr string) {
+	req, _ := http.NewRequest("GET", addr, nil)
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+}
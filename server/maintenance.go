@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"../alertmanager"
+	"../logging"
+)
+
+const maintenanceCreatedBy = "docker-flow-monitor"
+
+var defaultMaintenanceDuration = 30 * time.Minute
+
+// maintenanceWindow mutes the alerts of a service, optionally limited to a node,
+// through an Alertmanager silence. The ID of the window is the ID of the silence.
+type maintenanceWindow struct {
+	ID       string    `json:"id"`
+	Service  string    `json:"service"`
+	Node     string    `json:"node,omitempty"`
+	Comment  string    `json:"comment"`
+	StartsAt time.Time `json:"startsAt"`
+	EndsAt   time.Time `json:"endsAt"`
+	State    string    `json:"state"`
+}
+
+type maintenanceResponse struct {
+	Status  int
+	Message string
+	Windows []maintenanceWindow
+}
+
+func newMaintenanceWindow(silence *alertmanager.Silence) maintenanceWindow {
+	window := maintenanceWindow{
+		ID:       silence.ID,
+		Comment:  silence.Comment,
+		StartsAt: silence.StartsAt,
+		EndsAt:   silence.EndsAt,
+	}
+	if silence.Status != nil {
+		window.State = silence.Status.State
+	}
+	for _, m := range silence.Matchers {
+		switch m.Name {
+		case "service":
+			window.Service = m.Value
+		case "node":
+			window.Node = m.Value
+		}
+	}
+	return window
+}
+
+// isMaintenanceSilence returns true when the silence was created for a window that is still open
+func isMaintenanceSilence(silence *alertmanager.Silence) bool {
+	return silence.CreatedBy == maintenanceCreatedBy && (silence.Status == nil || silence.Status.State != "expired")
+}
+
+// maintenanceClient returns a client of the first Alertmanager defined through ARG_ALERTMANAGER_URL
+func maintenanceClient() (*alertmanager.Client, error) {
+	alertURLs := os.Getenv("ARG_ALERTMANAGER_URL")
+	if len(alertURLs) == 0 {
+		return nil, fmt.Errorf("ARG_ALERTMANAGER_URL is not defined")
+	}
+	return alertmanager.NewClient(strings.Split(alertURLs, ",")[0])
+}
+
+// MaintenanceHandler lists the open maintenance windows.
+// The serviceName query parameter limits the list to the windows of a service.
+func (s *serve) MaintenanceHandler(w http.ResponseWriter, req *http.Request) {
+	client, err := maintenanceClient()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	silences, err := client.Silences()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	serviceName := req.URL.Query().Get("serviceName")
+	windows := []maintenanceWindow{}
+	for i := range silences {
+		if !isMaintenanceSilence(&silences[i]) {
+			continue
+		}
+		window := newMaintenanceWindow(&silences[i])
+		if len(serviceName) > 0 && window.Service != serviceName {
+			continue
+		}
+		windows = append(windows, window)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		if !windows[i].EndsAt.Equal(windows[j].EndsAt) {
+			return windows[i].EndsAt.Before(windows[j].EndsAt)
+		}
+		return windows[i].ID < windows[j].ID
+	})
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: http.StatusOK, Message: "OK", Windows: windows})
+}
+
+// MaintenanceOpenHandler opens a maintenance window that mutes the alerts labeled with the service
+// and, when the node query parameter is set, with the node for the duration of the window
+func (s *serve) MaintenanceOpenHandler(w http.ResponseWriter, req *http.Request) {
+	logRequest(req)
+	query := req.URL.Query()
+	serviceName := query.Get("serviceName")
+	if len(serviceName) == 0 {
+		writeMessage(w, http.StatusBadRequest, "serviceName is not defined")
+		return
+	}
+	duration, err := getMaintenanceDuration(query.Get("duration"), defaultMaintenanceDuration)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	client, err := maintenanceClient()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	node := query.Get("node")
+	comment := query.Get("comment")
+	if len(comment) == 0 {
+		comment = fmt.Sprintf("Maintenance of the service %s", serviceName)
+	}
+	now := time.Now().UTC()
+	silence := alertmanager.Silence{
+		Matchers:  []alertmanager.Matcher{{Name: "service", Value: serviceName}},
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		CreatedBy: maintenanceCreatedBy,
+		Comment:   comment,
+	}
+	if len(node) > 0 {
+		silence.Matchers = append(silence.Matchers, alertmanager.Matcher{Name: "node", Value: node})
+	}
+	silence.ID, err = client.PostSilence(silence)
+	if err != nil {
+		logger.Error("Unable to open maintenance window", logging.Fields{"service": serviceName, "node": node, "error": err})
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.auditMaintenance(req, "maintenance-open", serviceName, node)
+	window := newMaintenanceWindow(&silence)
+	window.State = "active"
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: http.StatusOK, Message: "OK", Windows: []maintenanceWindow{window}})
+}
+
+// MaintenanceExtendHandler moves the end of an open maintenance window by the duration.
+// Alertmanager can assign a new ID to the window.
+func (s *serve) MaintenanceExtendHandler(w http.ResponseWriter, req *http.Request) {
+	logRequest(req)
+	query := req.URL.Query()
+	duration, err := getMaintenanceDuration(query.Get("duration"), defaultMaintenanceDuration)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	client, silence, ok := s.getMaintenanceSilence(w, query.Get("id"))
+	if !ok {
+		return
+	}
+	end := silence.EndsAt
+	if now := time.Now().UTC(); end.Before(now) {
+		end = now
+	}
+	silence.EndsAt = end.Add(duration)
+	id, err := client.PostSilence(*silence)
+	if err != nil {
+		logger.Error("Unable to extend maintenance window", logging.Fields{"id": silence.ID, "error": err})
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	silence.ID = id
+	window := newMaintenanceWindow(silence)
+	s.auditMaintenance(req, "maintenance-extend", window.Service, window.Node)
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: http.StatusOK, Message: "OK", Windows: []maintenanceWindow{window}})
+}
+
+// MaintenanceCloseHandler closes an open maintenance window before it ends
+func (s *serve) MaintenanceCloseHandler(w http.ResponseWriter, req *http.Request) {
+	logRequest(req)
+	client, silence, ok := s.getMaintenanceSilence(w, req.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+	if err := client.ExpireSilence(silence.ID); err != nil {
+		logger.Error("Unable to close maintenance window", logging.Fields{"id": silence.ID, "error": err})
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	window := newMaintenanceWindow(silence)
+	window.State = "expired"
+	window.EndsAt = time.Now().UTC()
+	s.auditMaintenance(req, "maintenance-close", window.Service, window.Node)
+	writeJSON(w, http.StatusOK, maintenanceResponse{Status: http.StatusOK, Message: "OK", Windows: []maintenanceWindow{window}})
+}
+
+// getMaintenanceSilence writes an error response when id is not the ID of an open maintenance window
+func (s *serve) getMaintenanceSilence(w http.ResponseWriter, id string) (*alertmanager.Client, *alertmanager.Silence, bool) {
+	if len(id) == 0 {
+		writeMessage(w, http.StatusBadRequest, "id is not defined")
+		return nil, nil, false
+	}
+	client, err := maintenanceClient()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+	silences, err := client.Silences()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return nil, nil, false
+	}
+	for i := range silences {
+		if silences[i].ID == id && isMaintenanceSilence(&silences[i]) {
+			return client, &silences[i], true
+		}
+	}
+	writeMessage(w, http.StatusNotFound, fmt.Sprintf("Maintenance window %s is not open", id))
+	return nil, nil, false
+}
+
+func (s *serve) auditMaintenance(req *http.Request, action, service, node string) {
+	record := newAuditRecord(getCaller(req), action, service, stateChange{})
+	record.Node = node
+	s.auditLog.add(record)
+}
+
+func getMaintenanceDuration(value string, defaultDuration time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return defaultDuration, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("duration %s is not a positive duration", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"../alertmanager"
+	"../prometheus"
+)
+
+// silencesStub is a stub of the Alertmanager silences API
+type silencesStub struct {
+	mu       sync.Mutex
+	next     int
+	silences map[string]*alertmanager.Silence
+	server   *httptest.Server
+}
+
+func newSilencesStub() *silencesStub {
+	stub := &silencesStub{next: 1, silences: map[string]*alertmanager.Silence{}}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v2/silences":
+			silences := []*alertmanager.Silence{}
+			for _, silence := range stub.silences {
+				silences = append(silences, silence)
+			}
+			json.NewEncoder(w).Encode(silences)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v2/silences":
+			silence := &alertmanager.Silence{}
+			json.NewDecoder(r.Body).Decode(silence)
+			if _, ok := stub.silences[silence.ID]; !ok {
+				silence.ID = fmt.Sprintf("silence-%d", stub.next)
+				stub.next++
+			}
+			silence.Status = &alertmanager.SilenceStatus{State: "active"}
+			stub.silences[silence.ID] = silence
+			fmt.Fprintf(w, `{"silenceID":"%s"}`, silence.ID)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/v2/silence/"):
+			silence, ok := stub.silences[strings.TrimPrefix(r.URL.Path, "/api/v2/silence/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			silence.Status.State = "expired"
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return stub
+}
+
+// MaintenanceOpenHandler
+
+func (s *ServerTestSuite) Test_MaintenanceOpenHandler_CreatesSilence() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	start := time.Now().UTC()
+	actual := maintenanceResponse{}
+
+	status := s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service&node=node-1&duration=10m&comment=Deploying", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().Len(actual.Windows, 1)
+	s.Equal("silence-1", actual.Windows[0].ID)
+	s.Equal("my-service", actual.Windows[0].Service)
+	s.Equal("node-1", actual.Windows[0].Node)
+	s.Equal("Deploying", actual.Windows[0].Comment)
+	silence := stub.silences["silence-1"]
+	s.Require().NotNil(silence)
+	s.Equal(maintenanceCreatedBy, silence.CreatedBy)
+	s.Equal([]alertmanager.Matcher{{Name: "service", Value: "my-service"}, {Name: "node", Value: "node-1"}}, silence.Matchers)
+	s.WithinDuration(start.Add(10*time.Minute), silence.EndsAt, time.Minute)
+	records := serve.auditLog.query("", "", time.Time{}, 0)
+	s.Require().Len(records, 1)
+	s.Equal("maintenance-open", records[0].Action)
+	s.Equal("my-service", records[0].Service)
+}
+
+func (s *ServerTestSuite) Test_MaintenanceOpenHandler_MatchesAllAlertRulesOfService() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&replicas=3"+
+		"&alertName=errors&alertIf=a>b&alertName.1=replicas&alertIf.1=@replicas_running")
+
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service", &maintenanceResponse{})
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service&node=node-1", &maintenanceResponse{})
+
+	s.Require().Len(serve.alerts, 2)
+	for name, alert := range serve.alerts {
+		labels := prometheus.RuleLabels(alert)
+		s.True(matchesSilence(labels, stub.silences["silence-1"]), name)
+		// Rules do not carry the node label; only series that keep it are muted by a node window.
+		s.False(matchesSilence(labels, stub.silences["silence-2"]), name)
+	}
+}
+
+func (s *ServerTestSuite) Test_MaintenanceOpenHandler_ReturnsBadRequest_WhenInputIsInvalid() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	testData := map[string]string{
+		"/v1/docker-flow-monitor/maintenance/open?duration=10m":                   "serviceName is not defined",
+		"/v1/docker-flow-monitor/maintenance/open?serviceName=a&duration=-10m":    "duration -10m is not a positive duration",
+		"/v1/docker-flow-monitor/maintenance/open?serviceName=a&duration=forever": "duration forever is not a positive duration",
+	}
+
+	for addr, expected := range testData {
+		actual := maintenanceResponse{}
+		status := s.serveJSON(serve.MaintenanceOpenHandler, addr, &actual)
+
+		s.Equal(http.StatusBadRequest, status, addr)
+		s.Equal(expected, actual.Message)
+	}
+	s.Empty(stub.silences)
+}
+
+func (s *ServerTestSuite) Test_MaintenanceOpenHandler_ReturnsError_WhenAlertmanagerFails() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer testServer.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", testServer.URL)
+	serve := New()
+	actual := maintenanceResponse{}
+
+	status := s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service", &actual)
+
+	s.Equal(http.StatusInternalServerError, status)
+	s.Contains(actual.Message, "alertmanager responded to POST /api/v2/silences with 500")
+}
+
+// MaintenanceHandler
+
+func (s *ServerTestSuite) Test_MaintenanceHandler_ReturnsOpenWindows() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service&duration=20m", &maintenanceResponse{})
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=other-service&duration=10m", &maintenanceResponse{})
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=closed-service", &maintenanceResponse{})
+	s.serveJSON(serve.MaintenanceCloseHandler, "/v1/docker-flow-monitor/maintenance/close?id=silence-3", &maintenanceResponse{})
+	stub.silences["manual"] = &alertmanager.Silence{ID: "manual", CreatedBy: "admin", Status: &alertmanager.SilenceStatus{State: "active"}}
+	actual := maintenanceResponse{}
+
+	status := s.serveJSON(serve.MaintenanceHandler, "/v1/docker-flow-monitor/maintenance", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().Len(actual.Windows, 2)
+	s.Equal("other-service", actual.Windows[0].Service)
+	s.Equal("my-service", actual.Windows[1].Service)
+
+	actual = maintenanceResponse{}
+	s.serveJSON(serve.MaintenanceHandler, "/v1/docker-flow-monitor/maintenance?serviceName=my-service", &actual)
+
+	s.Require().Len(actual.Windows, 1)
+	s.Equal("silence-1", actual.Windows[0].ID)
+}
+
+func (s *ServerTestSuite) Test_MaintenanceHandler_ReturnsError_WhenAlertmanagerIsNotDefined() {
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Unsetenv("ARG_ALERTMANAGER_URL")
+	serve := New()
+	actual := maintenanceResponse{}
+
+	status := s.serveJSON(serve.MaintenanceHandler, "/v1/docker-flow-monitor/maintenance", &actual)
+
+	s.Equal(http.StatusInternalServerError, status)
+	s.Equal("ARG_ALERTMANAGER_URL is not defined", actual.Message)
+}
+
+// MaintenanceExtendHandler
+
+func (s *ServerTestSuite) Test_MaintenanceExtendHandler_MovesEndOfWindow() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service&duration=10m", &maintenanceResponse{})
+	end := stub.silences["silence-1"].EndsAt
+	actual := maintenanceResponse{}
+
+	status := s.serveJSON(serve.MaintenanceExtendHandler, "/v1/docker-flow-monitor/maintenance/extend?id=silence-1&duration=15m", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().Len(actual.Windows, 1)
+	s.Equal("silence-1", actual.Windows[0].ID)
+	s.Equal("my-service", actual.Windows[0].Service)
+	s.True(end.Add(15 * time.Minute).Equal(stub.silences["silence-1"].EndsAt))
+}
+
+func (s *ServerTestSuite) Test_MaintenanceExtendHandler_ReturnsNotFound_WhenWindowIsNotOpen() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	stub.silences["manual"] = &alertmanager.Silence{ID: "manual", CreatedBy: "admin", Status: &alertmanager.SilenceStatus{State: "active"}}
+
+	for _, id := range []string{"unknown", "manual"} {
+		actual := maintenanceResponse{}
+		status := s.serveJSON(serve.MaintenanceExtendHandler, "/v1/docker-flow-monitor/maintenance/extend?id="+id, &actual)
+
+		s.Equal(http.StatusNotFound, status)
+		s.Equal(fmt.Sprintf("Maintenance window %s is not open", id), actual.Message)
+	}
+}
+
+// MaintenanceCloseHandler
+
+func (s *ServerTestSuite) Test_MaintenanceCloseHandler_ExpiresSilence() {
+	stub := newSilencesStub()
+	defer stub.server.Close()
+	defer os.Setenv("ARG_ALERTMANAGER_URL", os.Getenv("ARG_ALERTMANAGER_URL"))
+	os.Setenv("ARG_ALERTMANAGER_URL", stub.server.URL)
+	serve := New()
+	s.serveJSON(serve.MaintenanceOpenHandler, "/v1/docker-flow-monitor/maintenance/open?serviceName=my-service", &maintenanceResponse{})
+	actual := maintenanceResponse{}
+
+	status := s.serveJSON(serve.MaintenanceCloseHandler, "/v1/docker-flow-monitor/maintenance/close?id=silence-1", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().Len(actual.Windows, 1)
+	s.Equal("expired", actual.Windows[0].State)
+	s.Equal("expired", stub.silences["silence-1"].Status.State)
+
+	status = s.serveJSON(serve.MaintenanceCloseHandler, "/v1/docker-flow-monitor/maintenance/close?id=silence-1", &maintenanceResponse{})
+
+	s.Equal(http.StatusNotFound, status)
+}
+
+// matchesSilence returns whether labels satisfy all the equality matchers of silence
+func matchesSilence(labels map[string]string, silence *alertmanager.Silence) bool {
+	for _, m := range silence.Matchers {
+		if labels[m.Name] != m.Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"../logging"
+	"../prometheus"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var alertPoliciesPath = "/etc/dfm/alert-policies.yaml"
+
+// AlertPolicy defines default alerts for all services or, when Selector is set,
+// for the services whose reconfigure parameters have all the values in Selector
+type AlertPolicy struct {
+	Name     string            `yaml:"name"`
+	Selector map[string]string `yaml:"selector"`
+	Alerts   []PolicyAlert     `yaml:"alerts"`
+}
+
+// PolicyAlert is an alert defined the same way as through the reconfigure alert parameters.
+// AlertIf can use shortcuts.
+type PolicyAlert struct {
+	AlertName        string            `yaml:"alertName"`
+	AlertIf          string            `yaml:"alertIf"`
+	AlertFor         string            `yaml:"alertFor"`
+	AlertLabels      map[string]string `yaml:"alertLabels"`
+	AlertAnnotations map[string]string `yaml:"alertAnnotations"`
+}
+
+// readAlertPolicies reads alert policies from the file defined through DF_ALERT_POLICIES
+// or from /etc/dfm/alert-policies.yaml. Invalid policies are skipped.
+func readAlertPolicies() []*AlertPolicy {
+	path := alertPoliciesPath
+	if len(os.Getenv("DF_ALERT_POLICIES")) > 0 {
+		path = os.Getenv("DF_ALERT_POLICIES")
+	}
+	data, err := afero.ReadFile(FS, path)
+	if err != nil {
+		logger.Debug("No alert policies are configured", logging.Fields{"path": path})
+		return nil
+	}
+	policies := []*AlertPolicy{}
+	if err := yaml.Unmarshal(data, &policies); err != nil {
+		logger.Error("Unable to decode alert policies", logging.Fields{"path": path, "error": err})
+		return nil
+	}
+	valid := []*AlertPolicy{}
+	for i, p := range policies {
+		if len(p.Name) == 0 {
+			p.Name = fmt.Sprintf("policy-%d", i+1)
+		}
+		if err := p.validate(); err != nil {
+			logger.Error("Skipping alert policy", logging.Fields{"policy": p.Name, "error": err})
+			continue
+		}
+		valid = append(valid, p)
+	}
+	return valid
+}
+
+func (p *AlertPolicy) validate() error {
+	if len(p.Alerts) == 0 {
+		return fmt.Errorf("alerts are not defined")
+	}
+	for _, a := range p.Alerts {
+		if len(a.AlertName) == 0 || len(a.AlertIf) == 0 {
+			return fmt.Errorf("alertName and alertIf are required")
+		}
+	}
+	return nil
+}
+
+// matches returns true when get returns the value of each key of the selector
+func (p *AlertPolicy) matches(get func(string) string) bool {
+	for k, v := range p.Selector {
+		if get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// addPolicyAlerts adds the alerts of the policies that match the service.
+// get returns the reconfigure parameters of the service. Alerts with the same name that are
+// already registered, e.g. sent explicitly in the same request or defined by an earlier policy, are kept.
+func (s *serve) addPolicyAlerts(serviceName string, get func(string) string) []prometheus.Alert {
+	alerts := []prometheus.Alert{}
+	if len(serviceName) == 0 {
+		return alerts
+	}
+	replicas := 0
+	if len(get("replicas")) > 0 {
+		replicas, _ = strconv.Atoi(get("replicas"))
+	}
+	for _, p := range s.policies {
+		if !p.matches(get) {
+			continue
+		}
+		for _, pa := range p.Alerts {
+			alert := prometheus.Alert{
+				ServiceName:      serviceName,
+				AlertName:        pa.AlertName,
+				AlertIf:          pa.AlertIf,
+				AlertFor:         pa.AlertFor,
+				AlertLabels:      copyStringMap(pa.AlertLabels),
+				AlertAnnotations: copyStringMap(pa.AlertAnnotations),
+				Replicas:         replicas,
+			}
+			s.formatAlert(&alert)
+			inheritLabels(&alert, get)
+			if _, ok := s.alerts[alert.AlertNameFormatted]; ok {
+				continue
+			}
+			s.alerts[alert.AlertNameFormatted] = alert
+			logAlert(&alert)
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+var testPolicies = `- name: baseline
+  alerts:
+  - alertName: memlimit
+    alertIf: "@service_mem_limit:0.8"
+    alertFor: 5m
+  - alertName: replicas
+    alertIf: "@replicas_running"
+- name: payments
+  selector:
+    team: payments
+  alerts:
+  - alertName: errors
+    alertIf: rate(http_errors_total[5m]) > 1
+    alertLabels:
+      receiver: payments
+    alertAnnotations:
+      summary: Too many errors
+`
+
+func (s *ServerTestSuite) writePolicies(config string) {
+	afero.WriteFile(FS, "/etc/dfm/test-policies.yaml", []byte(config), 0644)
+	os.Setenv("DF_ALERT_POLICIES", "/etc/dfm/test-policies.yaml")
+	s.T().Cleanup(func() {
+		os.Unsetenv("DF_ALERT_POLICIES")
+		FS.Remove("/etc/dfm/test-policies.yaml")
+	})
+}
+
+// readAlertPolicies
+
+func (s *ServerTestSuite) Test_ReadAlertPolicies_SkipsInvalidPolicies() {
+	s.writePolicies(`- name: empty
+- name: no-condition
+  alerts:
+  - alertName: memlimit
+- alerts:
+  - alertName: memlimit
+    alertIf: a > b
+`)
+
+	policies := readAlertPolicies()
+
+	s.Require().Len(policies, 1)
+	s.Equal("policy-3", policies[0].Name)
+}
+
+func (s *ServerTestSuite) Test_ReadAlertPolicies_ReturnsNil_WhenFileDoesNotExist() {
+	s.Nil(readAlertPolicies())
+}
+
+// addPolicyAlerts
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsPolicyAlerts() {
+	s.writePolicies(testPolicies)
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&replicas=3")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=pay-service&team=payments")
+
+	s.Len(serve.alerts, 5)
+	memlimit := serve.alerts["myservice_memlimit"]
+	s.Equal(`container_memory_usage_bytes{container_label_com_docker_swarm_service_name="my-service"}/container_spec_memory_limit_bytes{container_label_com_docker_swarm_service_name="my-service"} > 0.8`, memlimit.AlertIf)
+	s.Equal("5m", memlimit.AlertFor)
+	s.Equal(map[string]string{"receiver": "system", "service": "my-service"}, memlimit.AlertLabels)
+	s.Contains(serve.alerts["myservice_replicas"].AlertIf, "!= 3")
+	s.NotContains(serve.alerts, "myservice_errors")
+	errors := serve.alerts["payservice_errors"]
+	s.Equal("rate(http_errors_total[5m]) > 1", errors.AlertIf)
+	s.Equal(map[string]string{"receiver": "payments"}, errors.AlertLabels)
+	s.Equal(map[string]string{"summary": "Too many errors"}, errors.AlertAnnotations)
+	s.Contains(serve.alerts, "payservice_memlimit")
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_KeepsExplicitAlerts_WhenPolicyDefinesTheSameName() {
+	s.writePolicies(testPolicies)
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&alertName=memlimit&alertIf=@service_mem_limit:0.9&alertFor=1m")
+
+	s.Contains(serve.alerts["myservice_memlimit"].AlertIf, "> 0.9")
+	s.Equal("1m", serve.alerts["myservice_memlimit"].AlertFor)
+	s.Contains(serve.alerts, "myservice_replicas")
+}
+
+func (s *ServerTestSuite) Test_RemoveHandler_RemovesPolicyAlerts() {
+	s.writePolicies(testPolicies)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/remove?serviceName=my-service", nil)
+
+	serve.RemoveHandler(ResponseWriterMock{}, req)
+
+	s.Empty(serve.alerts)
+}
+
+func (s *ServerTestSuite) Test_InitialConfig_AddsPolicyAlerts() {
+	s.writePolicies(testPolicies)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		js, _ := json.Marshal([]map[string]string{
+			{"serviceName": "pay-service", "scrapePort": "1234", "team": "payments", "alertName": "errors", "alertIf": "a > b"},
+		})
+		w.Write(js)
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("LISTENER_ADDRESS")
+	os.Setenv("LISTENER_ADDRESS", testServer.URL)
+	serve := New()
+
+	serve.InitialConfig()
+
+	s.Equal("a > b", serve.alerts["payservice_errors"].AlertIf)
+	s.Contains(serve.alerts, "payservice_memlimit")
+	s.Contains(serve.alerts, "payservice_replicas")
+}
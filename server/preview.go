@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"../prometheus"
+)
+
+var defaultPreviewHours = 24
+var defaultPreviewStep = time.Minute
+
+// maxPreviewPoints is the number of points per series Prometheus accepts in a range query
+const maxPreviewPoints = 11000
+
+// alertPreview is the result of evaluating an alertIf that is not registered
+type alertPreview struct {
+	AlertIf  string               `json:"alertIf"`
+	AlertFor string               `json:"alertFor,omitempty"`
+	Matching int                  `json:"matching"`
+	Series   []prometheus.Sample  `json:"series"`
+	Start    time.Time            `json:"start"`
+	End      time.Time            `json:"end"`
+	Step     string               `json:"step"`
+	Periods  []alertPreviewPeriod `json:"periods"`
+}
+
+// alertPreviewPeriod is a period in which at least one series matched the alertIf.
+// Fires is true when the period lasted at least as long as alertFor.
+type alertPreviewPeriod struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	Fires    bool      `json:"fires"`
+}
+
+type alertPreviewResponse struct {
+	Status  int
+	Message string
+	Preview *alertPreview `json:",omitempty"`
+}
+
+// AlertPreviewHandler expands the alertIf of a service the same way reconfigure does and evaluates it
+// against Prometheus without registering it. It returns the series that match now and the periods
+// of the last hours in which the condition was true.
+func (s *serve) AlertPreviewHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	alert := prometheus.Alert{
+		ServiceName: query.Get("serviceName"),
+		AlertName:   "preview",
+		AlertIf:     query.Get("alertIf"),
+		AlertFor:    query.Get("alertFor"),
+	}
+	if len(alert.ServiceName) == 0 {
+		writeMessage(w, http.StatusBadRequest, "serviceName is not defined")
+		return
+	}
+	if len(alert.AlertIf) == 0 {
+		writeMessage(w, http.StatusBadRequest, "alertIf is not defined")
+		return
+	}
+	if value := query.Get("replicas"); len(value) > 0 {
+		replicas, err := strconv.Atoi(value)
+		if err != nil || replicas < 0 {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("replicas %s is not a number", value))
+			return
+		}
+		alert.Replicas = replicas
+	}
+	alertFor := time.Duration(0)
+	if len(alert.AlertFor) > 0 {
+		d, err := parseDuration(alert.AlertFor)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("alertFor %s is not a duration", alert.AlertFor))
+			return
+		}
+		alertFor = d
+	}
+	hours := defaultPreviewHours
+	if value := query.Get("hours"); len(value) > 0 {
+		h, err := strconv.Atoi(value)
+		if err != nil || h <= 0 {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("hours %s is not a positive number", value))
+			return
+		}
+		hours = h
+	}
+	step := defaultPreviewStep
+	if value := query.Get("step"); len(value) > 0 {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("step %s is not a positive duration", value))
+			return
+		}
+		step = d
+	}
+	if time.Duration(hours)*time.Hour/step > maxPreviewPoints {
+		writeMessage(w, http.StatusBadRequest, fmt.Sprintf("%d hours with the step %s exceed %d points. Use a longer step", hours, step, maxPreviewPoints))
+		return
+	}
+	s.formatAlert(&alert)
+
+	end := time.Now().UTC().Truncate(time.Second)
+	preview := &alertPreview{
+		AlertIf:  alert.AlertIf,
+		AlertFor: alert.AlertFor,
+		Start:    end.Add(-time.Duration(hours) * time.Hour),
+		End:      end,
+		Step:     step.String(),
+	}
+	client := prometheus.NewAPIClient()
+	samples, err := client.Query(alert.AlertIf, end)
+	if err != nil {
+		writeAlertPreviewError(w, err)
+		return
+	}
+	series, err := client.QueryRange(alert.AlertIf, preview.Start, end, step)
+	if err != nil {
+		writeAlertPreviewError(w, err)
+		return
+	}
+	preview.Series = samples
+	preview.Matching = len(samples)
+	preview.Periods = getAlertPreviewPeriods(series, step, alertFor)
+	writeJSON(w, http.StatusOK, alertPreviewResponse{Status: http.StatusOK, Message: "OK", Preview: preview})
+}
+
+// getAlertPreviewPeriods returns the periods in which at least one series matched.
+// Points of a series that are at most a step apart belong to the same period, and each point
+// counts for the step it was evaluated at. Prometheus tracks alertFor per series, so a period fires
+// only when one of its series alone matched for longer than alertFor. Overlapping or adjacent
+// periods of different series are merged for display.
+func getAlertPreviewPeriods(series []prometheus.Series, step, alertFor time.Duration) []alertPreviewPeriod {
+	runs := []alertPreviewPeriod{}
+	for _, s := range series {
+		times := make([]time.Time, 0, len(s.Values))
+		for _, v := range s.Values {
+			times = append(times, v.Time)
+		}
+		sort.Slice(times, func(i, j int) bool {
+			return times[i].Before(times[j])
+		})
+		first := len(runs)
+		for _, t := range times {
+			if last := len(runs) - 1; last >= first && t.Sub(runs[last].End) <= step {
+				runs[last].End = t
+				continue
+			}
+			runs = append(runs, alertPreviewPeriod{Start: t, End: t})
+		}
+	}
+	for i := range runs {
+		runs[i].Fires = runs[i].End.Sub(runs[i].Start)+step > alertFor
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Start.Before(runs[j].Start)
+	})
+	periods := []alertPreviewPeriod{}
+	for _, r := range runs {
+		if last := len(periods) - 1; last >= 0 && r.Start.Sub(periods[last].End) <= step {
+			if r.End.After(periods[last].End) {
+				periods[last].End = r.End
+			}
+			periods[last].Fires = periods[last].Fires || r.Fires
+			continue
+		}
+		periods = append(periods, r)
+	}
+	for i := range periods {
+		periods[i].Duration = (periods[i].End.Sub(periods[i].Start) + step).String()
+	}
+	return periods
+}
+
+// writeAlertPreviewError responds with the status 400 when the query cannot be used as an alert condition
+func writeAlertPreviewError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if apiErr, ok := err.(*prometheus.APIError); err == prometheus.ErrNotInstantVector || (ok && apiErr.Type == "bad_data") {
+		status = http.StatusBadRequest
+	}
+	writeMessage(w, status, err.Error())
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"time"
+
+	"../prometheus"
+)
+
+// AlertPreviewHandler
+
+func (s *ServerTestSuite) Test_AlertPreviewHandler_EvaluatesExpandedAlertIf() {
+	queries := map[string]url.Values{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries[r.URL.Path] = r.URL.Query()
+		switch r.URL.Path {
+		case "/api/v1/query":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"container_label_com_docker_swarm_task_name":"my-service.1"},"value":[1614592800,"0.85"]}]}}`))
+		case "/api/v1/query_range":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{"container_label_com_docker_swarm_task_name":"my-service.1"},"values":[[1614589200,"0.9"],[1614589260,"0.9"],[1614589320,"0.9"],[1614590000,"0.9"]]},
+				{"metric":{"container_label_com_docker_swarm_task_name":"my-service.2"},"values":[[1614589380,"0.9"],[1614590060,"0.9"]]}]}}`))
+		}
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	expectedAlertIf := `container_memory_usage_bytes{container_label_com_docker_swarm_service_name="my-service"}/container_spec_memory_limit_bytes{container_label_com_docker_swarm_service_name="my-service"} > 0.8`
+	actual := alertPreviewResponse{}
+
+	status := s.serveJSON(serve.AlertPreviewHandler, "/v1/docker-flow-monitor/alerts/preview?serviceName=my-service&alertIf=@service_mem_limit:0.8&alertFor=2m&hours=6&step=1m", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().NotNil(actual.Preview)
+	s.Equal(expectedAlertIf, actual.Preview.AlertIf)
+	s.Equal(expectedAlertIf, queries["/api/v1/query"].Get("query"))
+	s.Equal(expectedAlertIf, queries["/api/v1/query_range"].Get("query"))
+	s.Equal("60", queries["/api/v1/query_range"].Get("step"))
+	s.Equal(6*time.Hour, actual.Preview.End.Sub(actual.Preview.Start))
+	s.Equal(1, actual.Preview.Matching)
+	s.Equal("0.85", actual.Preview.Series[0].Value.Value)
+	s.Equal([]alertPreviewPeriod{
+		{Start: time.Unix(1614589200, 0).UTC(), End: time.Unix(1614589380, 0).UTC(), Duration: "4m0s", Fires: true},
+		{Start: time.Unix(1614590000, 0).UTC(), End: time.Unix(1614590060, 0).UTC(), Duration: "2m0s", Fires: false},
+	}, actual.Preview.Periods)
+}
+
+func (s *ServerTestSuite) Test_AlertPreviewHandler_AcceptsPrometheusDurations() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/query":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+		case "/api/v1/query_range":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+				{"metric":{},"values":[[1614589200,"1"],[1614589260,"1"]]}]}}`))
+		}
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	actual := alertPreviewResponse{}
+
+	status := s.serveJSON(serve.AlertPreviewHandler, "/v1/docker-flow-monitor/alerts/preview?serviceName=my-service&alertIf=a>b&alertFor=1d", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().NotNil(actual.Preview)
+	s.Equal("1d", actual.Preview.AlertFor)
+	s.Require().Len(actual.Preview.Periods, 1)
+	s.False(actual.Preview.Periods[0].Fires)
+}
+
+func (s *ServerTestSuite) Test_AlertPreviewHandler_ReturnsBadRequest_WhenInputIsInvalid() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error at char 3"}`))
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	testData := map[string]string{
+		"/v1/docker-flow-monitor/alerts/preview?alertIf=a>b":                                "serviceName is not defined",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a":                              "alertIf is not defined",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a&alertIf=a>b&alertFor=later":   "alertFor later is not a duration",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a&alertIf=a>b&replicas=all":     "replicas all is not a number",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a&alertIf=a>b&hours=0":          "hours 0 is not a positive number",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a&alertIf=a>b&step=0s":          "step 0s is not a positive duration",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a&alertIf=a>b&hours=24&step=1s": "24 hours with the step 1s exceed 11000 points. Use a longer step",
+		"/v1/docker-flow-monitor/alerts/preview?serviceName=a&alertIf=a>>b":                 "prometheus responded to /api/v1/query with bad_data: parse error at char 3",
+	}
+
+	for addr, expected := range testData {
+		actual := alertPreviewResponse{}
+		status := s.serveJSON(serve.AlertPreviewHandler, addr, &actual)
+
+		s.Equal(http.StatusBadRequest, status, addr)
+		s.Equal(expected, actual.Message)
+		s.Nil(actual.Preview)
+	}
+}
+
+// getAlertPreviewPeriods
+
+func (s *ServerTestSuite) Test_GetAlertPreviewPeriods_ReturnsNoPeriods_WhenNothingMatched() {
+	s.Equal([]alertPreviewPeriod{}, getAlertPreviewPeriods([]prometheus.Series{}, time.Minute, 0))
+}
+
+func (s *ServerTestSuite) Test_GetAlertPreviewPeriods_DoesNotFire_WhenSeriesAlternate() {
+	at := func(minute int64) prometheus.SamplePair {
+		return prometheus.SamplePair{Time: time.Unix(1614589200+minute*60, 0).UTC(), Value: "1"}
+	}
+	series := []prometheus.Series{
+		{Values: []prometheus.SamplePair{at(0), at(2), at(4)}},
+		{Values: []prometheus.SamplePair{at(1), at(3)}},
+	}
+
+	actual := getAlertPreviewPeriods(series, time.Minute, 2*time.Minute)
+
+	s.Equal([]alertPreviewPeriod{
+		{Start: at(0).Time, End: at(4).Time, Duration: "5m0s", Fires: false},
+	}, actual)
+}
+
+func (s *ServerTestSuite) Test_GetAlertPreviewPeriods_CountsTheStepOfEachPoint() {
+	at := func(minute int64) prometheus.SamplePair {
+		return prometheus.SamplePair{Time: time.Unix(1614589200+minute*60, 0).UTC(), Value: "1"}
+	}
+	series := []prometheus.Series{{Values: []prometheus.SamplePair{at(0), at(10), at(11), at(20), at(21), at(22)}}}
+
+	actual := getAlertPreviewPeriods(series, time.Minute, 2*time.Minute)
+
+	s.Equal([]alertPreviewPeriod{
+		{Start: at(0).Time, End: at(0).Time, Duration: "1m0s", Fires: false},
+		{Start: at(10).Time, End: at(11).Time, Duration: "2m0s", Fires: false},
+		{Start: at(20).Time, End: at(22).Time, Duration: "3m0s", Fires: true},
+	}, actual)
+}
@@ -6,15 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"../alertmanager"
+	"../logging"
 	"../prometheus"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
@@ -26,7 +30,7 @@ import (
 var FS = afero.NewOsFs()
 var decoder = schema.NewDecoder()
 var mu = &sync.Mutex{}
-var logPrintf = log.Printf
+var logger = logging.Default
 var listenerTimeout = 30 * time.Second
 var shortcutsPath = "/etc/dfm/shortcuts.yaml"
 var alertIfShortcutData map[string]AlertIfShortcut
@@ -36,15 +40,33 @@ type serve struct {
 	alerts     map[string]prometheus.Alert
 	nodeLabels map[string]map[string]string
 	configPath string
+	history    *configHistory
+	auditLog   *auditLog
+	events     *eventBroker
+	webhooks   *webhookSender
+	receivers  *alertmanager.Catalogue
+	policies   []*AlertPolicy
 }
 
 type response struct {
 	Status  int
 	Message string
 	Alerts  []prometheus.Alert
+	Scrapes []prometheus.Scrape `json:",omitempty"`
 	prometheus.Scrape
 }
 
+type messageResponse struct {
+	Status  int
+	Message string
+}
+
+type logLevelResponse struct {
+	Status  int
+	Message string
+	Level   string
+}
+
 type nodeResponse struct {
 	Status    int
 	NodeID    string
@@ -69,12 +91,33 @@ var New = func() *serve {
 		scrapes:    make(map[string]prometheus.Scrape),
 		nodeLabels: make(map[string]map[string]string),
 		configPath: promConfig,
+		history:    newConfigHistory(),
+		auditLog:   newAuditLog(),
+		events:     newEventBroker(),
+		webhooks:   newWebhookSender(),
+		receivers:  readReceiverCatalogue(),
+		policies:   readAlertPolicies(),
 	}
 }
 
 func (s *serve) Execute() error {
+	if errs := prometheus.ValidateEnv(); len(errs) > 0 {
+		for _, err := range errs {
+			logger.Error("Invalid Prometheus setting", logging.Fields{"variable": err.Variable, "path": err.Path, "error": err.Message})
+		}
+		if strings.ToLower(os.Getenv("DF_STRICT_CONFIG")) == "true" {
+			err := fmt.Errorf("%d environment variables cannot be inserted into the Prometheus config", len(errs))
+			logger.Error("Docker Flow Monitor stopped", logging.Fields{"error": err})
+			return err
+		}
+	}
+	if err := prometheus.ValidateLayout(); err != nil {
+		logger.Error("Docker Flow Monitor stopped", logging.Fields{"error": err})
+		return err
+	}
 	s.InitialConfig()
 	prometheus.WriteConfig(s.configPath, s.scrapes, s.alerts, s.nodeLabels)
+	s.recordConfig(nil)
 	go prometheus.Run()
 	address := "0.0.0.0:8080"
 	r := mux.NewRouter().StrictSlash(true)
@@ -82,17 +125,60 @@ func (s *serve) Execute() error {
 	r.HandleFunc("/v1/docker-flow-monitor/remove", s.RemoveHandler)
 	r.HandleFunc("/v1/docker-flow-monitor/node/reconfigure", s.ReconfigureNodeHandler)
 	r.HandleFunc("/v1/docker-flow-monitor/node/remove", s.RemoveNodeHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/config/versions", s.ConfigVersionsHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/config/diff", s.ConfigDiffHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/config/rollback", s.ConfigRollbackHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/config/conflicts", s.ConfigConflictsHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/config/errors", s.ConfigErrorsHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/audit", s.AuditHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/events", s.EventsHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/targets", s.TargetsHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/targets/health", s.TargetHealthHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/alerts/state", s.AlertStateHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/alerts/preview", s.AlertPreviewHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/alertmanager/config", s.AlertmanagerConfigHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/alertmanager/unrouted", s.AlertmanagerUnroutedHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/maintenance", s.MaintenanceHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/maintenance/open", s.MaintenanceOpenHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/maintenance/extend", s.MaintenanceExtendHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/maintenance/close", s.MaintenanceCloseHandler)
+	r.HandleFunc("/v1/docker-flow-monitor/log-level", s.LogLevelHandler)
 	r.HandleFunc("/v1/docker-flow-monitor/ping", s.PingHandler)
 	// TODO: Do we need catch all?
 	r.HandleFunc("/v1/docker-flow-monitor/", s.EmptyHandler)
-	logPrintf("Starting Docker Flow Monitor")
+	logger.Info("Starting Docker Flow Monitor", logging.Fields{"address": address})
 	if err := httpListenAndServe(address, r); err != nil {
-		logPrintf(err.Error())
+		logger.Error("Docker Flow Monitor stopped", logging.Fields{"error": err})
 		return err
 	}
 	return nil
 }
 
+func (s *serve) LogLevelHandler(w http.ResponseWriter, req *http.Request) {
+	resp := logLevelResponse{Status: http.StatusOK}
+	if name := req.URL.Query().Get("level"); len(name) > 0 {
+		if level, err := logging.ParseLevel(name); err != nil {
+			resp.Status = http.StatusBadRequest
+			resp.Message = err.Error()
+		} else {
+			logger.Info("Changing log level", logging.Fields{"from": logger.Level().String(), "to": level.String()})
+			logger.SetLevel(level)
+		}
+	}
+	resp.Level = logger.Level().String()
+	writeJSON(w, resp.Status, resp)
+}
+
+// ConfigConflictsHandler returns the generated settings that conflict with the base configuration
+func (s *serve) ConfigConflictsHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, prometheus.BaseConfigConflicts())
+}
+
+// ConfigErrorsHandler returns the environment variables that cannot be inserted into the Prometheus config
+func (s *serve) ConfigErrorsHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, prometheus.ValidateEnv())
+}
+
 func (s *serve) PingHandler(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -106,93 +192,104 @@ func (s *serve) EmptyHandler(w http.ResponseWriter, req *http.Request) {
 func (s *serve) ReconfigureHandler(w http.ResponseWriter, req *http.Request) {
 	mu.Lock()
 	defer mu.Unlock()
-	logPrintf("Processing " + req.URL.String())
+	logRequest(req)
 	req.ParseForm()
-	scrape := s.getScrape(req)
+	before := s.snapshot()
+	scrape, scrapes, err := s.getScrape(req)
+	if err != nil {
+		resp := response{Status: http.StatusBadRequest, Message: err.Error(), Scrape: scrape}
+		writeJSON(w, resp.Status, resp)
+		return
+	}
 	s.deleteAlerts(scrape.ServiceName, false)
 	alerts := s.getAlerts(req)
-	prometheus.WriteConfig(s.configPath, s.scrapes, s.alerts, s.nodeLabels)
-	err := prometheus.Reload()
+	alerts = append(alerts, s.addPolicyAlerts(scrape.ServiceName, req.Form.Get)...)
+	alerts = append(alerts, s.addTargetDownAlerts(scrape.ServiceName, req.Form.Get)...)
+	prometheus.WriteServiceConfig(s.configPath, scrape.ServiceName, s.scrapes, s.alerts, s.nodeLabels)
+	s.recordConfig(req)
+	err = prometheus.Reload()
+	s.audit(req, "reconfigure", req.Form.Get("serviceName"), "", before, err)
+	s.publishChanges(before, err)
 	statusCode := http.StatusOK
 	resp := s.getResponse(&alerts, &scrape, err, statusCode)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.Status)
-	js, _ := json.Marshal(resp)
-	w.Write(js)
+	resp.Scrapes = scrapes
+	writeJSON(w, resp.Status, resp)
 }
 
 func (s *serve) RemoveHandler(w http.ResponseWriter, req *http.Request) {
 	mu.Lock()
 	defer mu.Unlock()
-	logPrintf("Processing " + req.URL.Path)
+	logRequest(req)
 	req.ParseForm()
+	before := s.snapshot()
 	serviceName := req.URL.Query().Get("serviceName")
 	scrape := s.scrapes[serviceName]
-	delete(s.scrapes, serviceName)
+	scrapes := s.deleteScrapes(serviceName)
 	alerts := s.deleteAlerts(serviceName, true)
-	prometheus.WriteConfig(s.configPath, s.scrapes, s.alerts, s.nodeLabels)
+	prometheus.WriteServiceConfig(s.configPath, serviceName, s.scrapes, s.alerts, s.nodeLabels)
+	s.recordConfig(req)
 	err := prometheus.Reload()
+	s.audit(req, "remove", serviceName, "", before, err)
+	s.publishChanges(before, err)
 	statusCode := http.StatusOK
 	resp := s.getResponse(&alerts, &scrape, err, statusCode)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.Status)
-	js, _ := json.Marshal(resp)
-	w.Write(js)
+	for _, removed := range scrapes {
+		if removed.ScrapeIndex > 0 {
+			resp.Scrapes = append(resp.Scrapes, removed)
+		}
+	}
+	writeJSON(w, resp.Status, resp)
 }
 
 func (s *serve) ReconfigureNodeHandler(w http.ResponseWriter, req *http.Request) {
 	mu.Lock()
 	defer mu.Unlock()
-	logPrintf("Processing " + req.URL.String())
+	logRequest(req)
 	req.ParseForm()
+	before := s.snapshot()
 	nodeID, nodeLabel, err := s.getNodeLabel(req)
 	if err != nil {
 		status := http.StatusBadRequest
 		resp := s.getNoIDNodeResponse(err, status)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(status)
-		js, _ := json.Marshal(resp)
-		w.Write(js)
+		writeJSON(w, status, resp)
 		return
 	}
 
 	prometheus.WriteConfig(s.configPath, s.scrapes, s.alerts, s.nodeLabels)
+	s.recordConfig(req)
 	err = prometheus.Reload()
+	s.audit(req, "node-reconfigure", "", nodeID, before, err)
+	s.publishChanges(before, err)
 	statusCode := http.StatusOK
 	resp := s.getNodeResponse(nodeID, nodeLabel, err, statusCode)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	js, _ := json.Marshal(resp)
-	w.Write(js)
+	writeJSON(w, statusCode, resp)
 }
 
 func (s *serve) RemoveNodeHandler(w http.ResponseWriter, req *http.Request) {
 
 	mu.Lock()
 	defer mu.Unlock()
-	logPrintf("Processing " + req.URL.String())
+	logRequest(req)
 	req.ParseForm()
 	nodeID := req.URL.Query().Get("id")
 	if len(nodeID) == 0 {
 		status := http.StatusBadRequest
 		resp := s.getNoIDNodeResponse(errors.New("node id not in query"), status)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(status)
-		js, _ := json.Marshal(resp)
-		w.Write(js)
+		writeJSON(w, status, resp)
 		return
 	}
+	before := s.snapshot()
 	nodeLabel := s.nodeLabels[nodeID]
 	delete(s.nodeLabels, nodeID)
 
 	prometheus.WriteConfig(s.configPath, s.scrapes, s.alerts, s.nodeLabels)
+	s.recordConfig(req)
 	err := prometheus.Reload()
+	s.audit(req, "node-remove", "", nodeID, before, err)
+	s.publishChanges(before, err)
 	statusCode := http.StatusOK
 	resp := s.getNodeResponse(nodeID, nodeLabel, err, statusCode)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	js, _ := json.Marshal(resp)
-	w.Write(js)
+	writeJSON(w, statusCode, resp)
 }
 
 func (s *serve) InitialConfig() error {
@@ -200,13 +297,13 @@ func (s *serve) InitialConfig() error {
 		addrs := strings.Split(os.Getenv("LISTENER_ADDRESS"), ",")
 		for _, addr := range addrs {
 			addr = strings.TrimSpace(addr)
-			
+
 			if !strings.HasPrefix(addr, "http") {
 				addr = fmt.Sprintf("http://%s:8080", addr)
 			}
-			
-			logPrintf("Requesting services from %s", addr)
-			
+
+			logger.Info("Requesting services from Docker Flow Swarm Listener", logging.Fields{"address": addr})
+
 			addr = fmt.Sprintf("%s/v1/docker-flow-swarm-listener/get-services", addr)
 			timeout := time.Duration(listenerTimeout)
 			client := http.Client{Timeout: timeout}
@@ -215,12 +312,16 @@ func (s *serve) InitialConfig() error {
 				return err
 			}
 			body, _ := ioutil.ReadAll(resp.Body)
-			logPrintf("Processing: %s", string(body))
+			logger.Debug("Processing services", logging.Fields{"body": string(body)})
 			data := []map[string]string{}
 			json.Unmarshal(body, &data)
+			before := s.snapshot()
 			for _, row := range data {
-				if scrape, err := s.getScrapeFromMap(row); err == nil {
-					s.scrapes[scrape.ServiceName] = scrape
+				if scrapes, err := s.getScrapesFromMap(row); err == nil {
+					s.deleteScrapes(row["serviceName"])
+					for _, scrape := range scrapes {
+						s.scrapes[scrape.JobName()] = scrape
+					}
 				}
 				if alert, err := s.getAlertFromMap(row, ""); err == nil {
 					s.alerts[alert.AlertNameFormatted] = alert
@@ -233,6 +334,9 @@ func (s *serve) InitialConfig() error {
 						break
 					}
 				}
+				get := func(key string) string { return row[key] }
+				s.addPolicyAlerts(row["serviceName"], get)
+				s.addTargetDownAlerts(row["serviceName"], get)
 			}
 
 			scrapeVariablesFromEnv := s.getScrapeVariablesFromEnv()
@@ -243,30 +347,34 @@ func (s *serve) InitialConfig() error {
 				}
 				for _, row := range scrape {
 					s.scrapes[row.ServiceName] = row
+					get := func(string) string { return "" }
+					s.addPolicyAlerts(row.ServiceName, get)
+					s.addTargetDownAlerts(row.ServiceName, get)
 				}
 			}
+			s.auditImport(addr, before)
 		}
 	}
 
 	// Get Nodes
 	// Will return nil for errors since nodeLabels are not needed for DFM to function
 	if len(os.Getenv("DF_GET_NODES_URL")) > 0 {
-		logPrintf("Requesting nodes from Docker Flow Swarm Listener")
+		logger.Info("Requesting nodes from Docker Flow Swarm Listener", logging.Fields{"address": os.Getenv("DF_GET_NODES_URL")})
 		timeout := time.Duration(listenerTimeout)
 		client := http.Client{Timeout: timeout}
 
 		resp, err := client.Get(os.Getenv("DF_GET_NODES_URL"))
 		if err != nil {
-			logPrintf("Error with node request: %v", err)
+			logger.Error("Unable to request nodes", logging.Fields{"error": err})
 			return nil
 		}
 		body, err := ioutil.ReadAll(resp.Body)
 		defer resp.Body.Close()
 		if err != nil {
-			logPrintf("Error with respone body parsing: %v", err)
+			logger.Error("Unable to read nodes response", logging.Fields{"error": err})
 			return nil
 		}
-		logPrintf("Processing: %s", string(body))
+		logger.Debug("Processing nodes", logging.Fields{"body": string(body)})
 		data := []map[string]string{}
 		json.Unmarshal(body, &data)
 
@@ -274,6 +382,8 @@ func (s *serve) InitialConfig() error {
 			return nil
 		}
 		nodeTargetLabels := s.getTargetLabelsFromEnv()
+		before := s.snapshot()
+		defer s.auditImport(os.Getenv("DF_GET_NODES_URL"), before)
 		for _, row := range data {
 			nodeID, ok := row["id"]
 			if !ok {
@@ -293,14 +403,29 @@ func (s *serve) InitialConfig() error {
 	return nil
 }
 
-func (s *serve) getScrapeFromMap(data map[string]string) (prometheus.Scrape, error) {
+// getScrapesFromMap returns the scrape defined with scrapePort, if any, followed by the scrapes
+// defined with indexed ports
+func (s *serve) getScrapesFromMap(data map[string]string) ([]prometheus.Scrape, error) {
 	scrape := prometheus.Scrape{}
 	if port, err := strconv.Atoi(data["scrapePort"]); err == nil {
 		scrape.ScrapePort = port
 	}
+	scrape.MetricsPath = data["metricsPath"]
 	scrape.ScrapeInterval = data["scrapeInterval"]
 	scrape.ServiceName = data["serviceName"]
 	scrape.ScrapeType = data["scrapeType"]
+	scrape.ScrapeScheme = data["scrapeScheme"]
+	scrape.ScrapeBasicAuthUsername = data["scrapeBasicAuthUsername"]
+	scrape.ScrapeBasicAuthPasswordSecret = data["scrapeBasicAuthPasswordSecret"]
+	scrape.ScrapeBearerTokenSecret = data["scrapeBearerTokenSecret"]
+	scrape.ScrapeTLSCASecret = data["scrapeTlsCaSecret"]
+	scrape.ScrapeTLSCertSecret = data["scrapeTlsCertSecret"]
+	scrape.ScrapeTLSKeySecret = data["scrapeTlsKeySecret"]
+	scrape.ScrapeTLSServerName = data["scrapeTlsServerName"]
+	scrape.ScrapeProxyURL = data["scrapeProxyUrl"]
+	scrape.ScrapeDNSType = data["scrapeDnsType"]
+	scrape.ScrapeDNSRefreshInterval = data["scrapeDnsRefreshInterval"]
+	scrape.ScrapeAddressFamily = data["scrapeAddressFamily"]
 
 	if nodeInfoStr, ok := data["nodeInfo"]; ok && len(nodeInfoStr) > 0 {
 		nodeInfo := prometheus.NodeIPSet{}
@@ -320,10 +445,16 @@ func (s *serve) getScrapeFromMap(data map[string]string) (prometheus.Scrape, err
 		}
 	}
 
-	if s.isValidScrape(&scrape) {
-		return scrape, nil
+	get := func(key string) string { return data[key] }
+	if len(scrape.ServiceName) == 0 || (!s.isValidScrape(&scrape) && len(get("scrapePort.1")) == 0) {
+		return nil, fmt.Errorf("Not a valid scrape")
 	}
-	return prometheus.Scrape{}, fmt.Errorf("Not a valid scrape")
+	scrapes, err := s.getScrapes(scrape, get)
+	if err != nil {
+		logger.Warn("Skipping scrape with invalid options", logging.Fields{"service": scrape.ServiceName, "error": err})
+		return nil, err
+	}
+	return scrapes, nil
 }
 
 func (s *serve) getAlertFromMap(data map[string]string, suffix string) (prometheus.Alert, error) {
@@ -340,6 +471,7 @@ func (s *serve) getAlertFromMap(data map[string]string, suffix string) (promethe
 		}
 		s.formatAlert(&alert)
 		if s.isValidAlert(&alert) {
+			inheritLabels(&alert, func(key string) string { return data[key] })
 			return alert, nil
 		}
 	}
@@ -365,9 +497,10 @@ func (s *serve) getAlerts(req *http.Request) []prometheus.Alert {
 		alertDecode.AlertAnnotations = s.getMapFromString(req.URL.Query().Get("alertAnnotations"))
 		alertDecode.AlertLabels = s.getMapFromString(req.URL.Query().Get("alertLabels"))
 		s.formatAlert(&alertDecode)
+		inheritLabels(&alertDecode, req.Form.Get)
 		s.alerts[alertDecode.AlertNameFormatted] = alertDecode
 		alerts = append(alerts, alertDecode)
-		logPrintf("Adding alert %s for the service %s\n", alertDecode.AlertName, alertDecode.ServiceName, alertDecode)
+		logAlert(&alertDecode)
 	}
 	replicas := 0
 	if len(req.URL.Query().Get("replicas")) > 0 {
@@ -393,8 +526,9 @@ func (s *serve) getAlerts(req *http.Request) []prometheus.Alert {
 		if !s.isValidAlert(&alert) {
 			break
 		}
+		inheritLabels(&alert, req.Form.Get)
 		s.alerts[alert.AlertNameFormatted] = alert
-		logPrintf("Adding alert %s for the service %s\n", alert.AlertName, alert.ServiceName, alert)
+		logAlert(&alert)
 		alerts = append(alerts, alert)
 	}
 	return alerts
@@ -416,14 +550,14 @@ type alertTemplateInput struct {
 func GetShortcuts() map[string]AlertIfShortcut {
 	yamlData, err := afero.ReadFile(FS, shortcutsPath)
 	if err != nil {
-		logPrintf(err.Error())
+		logger.Error("Unable to read shortcuts", logging.Fields{"path": shortcutsPath, "error": err})
 		return map[string]AlertIfShortcut{}
 	}
 	shortcuts := map[string]AlertIfShortcut{}
 	err = yaml.Unmarshal(yamlData, &shortcuts)
 
 	if err != nil {
-		logPrintf(err.Error())
+		logger.Error("Unable to decode shortcuts", logging.Fields{"path": shortcutsPath, "error": err})
 		return map[string]AlertIfShortcut{}
 	}
 
@@ -434,7 +568,7 @@ func GetShortcuts() map[string]AlertIfShortcut {
 	// Load alertIf shortcuts from secrets
 	files, err := afero.ReadDir(FS, "/run/secrets")
 	if err != nil {
-		logPrintf(err.Error())
+		logger.Error("Unable to read secrets", logging.Fields{"path": "/run/secrets", "error": err})
 		return shortcuts
 	}
 
@@ -451,14 +585,14 @@ func GetShortcuts() map[string]AlertIfShortcut {
 		path := fmt.Sprintf("/run/secrets/%s", file.Name())
 		yamlData, err = afero.ReadFile(FS, path)
 		if err != nil {
-			logPrintf("Unable to read %s, error: %v", path, err)
+			logger.Error("Unable to read shortcuts", logging.Fields{"path": path, "error": err})
 			continue
 		}
 
 		secretShortcuts := map[string]AlertIfShortcut{}
 		err = yaml.Unmarshal(yamlData, &secretShortcuts)
 		if err != nil {
-			logPrintf("YAML decoding reading %s, error: %v", path, err)
+			logger.Error("Unable to decode shortcuts", logging.Fields{"path": path, "error": err})
 			continue
 		}
 
@@ -617,6 +751,33 @@ func replaceTags(tag string, alert *prometheus.Alert, value string) string {
 	return b.String()
 }
 
+// inheritLabels copies the values of the parameters listed in DF_ALERT_INHERIT_LABELS into the labels of alert.
+// Labels the alert already has, explicitly or from a shortcut, are kept.
+// Names that are not valid Prometheus label names are skipped.
+func inheritLabels(alert *prometheus.Alert, get func(string) string) {
+	names := os.Getenv("DF_ALERT_INHERIT_LABELS")
+	if len(names) == 0 {
+		return
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		value := get(name)
+		if len(name) == 0 || len(value) == 0 {
+			continue
+		}
+		if !prometheus.IsValidLabelName(name) {
+			logger.Warn("Skipping inherited label with an invalid name", logging.Fields{"service": alert.ServiceName, "alert": alert.AlertName, "label": name})
+			continue
+		}
+		if alert.AlertLabels == nil {
+			alert.AlertLabels = map[string]string{}
+		}
+		if _, ok := alert.AlertLabels[name]; !ok {
+			alert.AlertLabels[name] = value
+		}
+	}
+}
+
 func (s *serve) isValidAlert(alert *prometheus.Alert) bool {
 	return len(alert.AlertName) > 0 && len(alert.AlertIf) > 0
 }
@@ -636,6 +797,33 @@ func (s *serve) deleteAlerts(
 	return alerts
 }
 
+func logRequest(req *http.Request) {
+	logger.Info("Processing request", logging.Fields{"method": req.Method, "url": req.URL.String()})
+}
+
+// writeJSON writes resp encoded as JSON with the status
+func writeJSON(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	js, _ := json.Marshal(resp)
+	w.Write(js)
+}
+
+// writeMessage writes a JSON response that contains only the status and the message
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, messageResponse{Status: status, Message: message})
+}
+
+func logAlert(alert *prometheus.Alert) {
+	logger.Info("Adding alert", logging.Fields{"service": alert.ServiceName, "alert": alert.AlertNameFormatted})
+	logger.Debug("Alert definition", logging.Fields{
+		"service": alert.ServiceName,
+		"alert":   alert.AlertNameFormatted,
+		"expr":    alert.AlertIf,
+		"for":     alert.AlertFor,
+	})
+}
+
 func (s *serve) getNameFormatted(name string) string {
 	return strings.Replace(name, "-", "", -1)
 }
@@ -657,11 +845,14 @@ func (s *serve) getNodeLabel(req *http.Request) (string, map[string]string, erro
 	return nodeID, nodeLabel, nil
 }
 
-func (s *serve) getScrape(req *http.Request) prometheus.Scrape {
+// getScrape returns the scrape decoded from the request and the scrapes defined with indexed ports.
+// The decoded scrape is registered only when it is valid on its own.
+// Scrapes registered earlier for the same service are replaced.
+func (s *serve) getScrape(req *http.Request) (prometheus.Scrape, []prometheus.Scrape, error) {
 	scrape := prometheus.Scrape{}
 	decoder.Decode(&scrape, req.Form)
-	if !s.isValidScrape(&scrape) {
-		return scrape
+	if len(scrape.ServiceName) == 0 || (!s.isValidScrape(&scrape) && len(req.Form.Get("scrapePort.1")) == 0) {
+		return scrape, nil, nil
 	}
 
 	if nodeInfoStr := req.Form.Get("nodeInfo"); len(nodeInfoStr) > 0 {
@@ -683,14 +874,245 @@ func (s *serve) getScrape(req *http.Request) prometheus.Scrape {
 		}
 	}
 
-	s.scrapes[scrape.ServiceName] = scrape
-	logPrintf("Adding scrape %s\n%v", scrape.ServiceName, scrape)
+	scrapes, err := s.getScrapes(scrape, req.Form.Get)
+	if err != nil {
+		return scrape, nil, err
+	}
+	s.deleteScrapes(scrape.ServiceName)
+	indexed := []prometheus.Scrape{}
+	for _, sc := range scrapes {
+		s.scrapes[sc.JobName()] = sc
+		logger.Info("Adding scrape", logging.Fields{"service": sc.ServiceName, "job": sc.JobName(), "port": sc.ScrapePort, "type": sc.ScrapeType})
+		logger.Debug("Scrape definition", logging.Fields{"service": sc.ServiceName, "job": sc.JobName(), "scrape": fmt.Sprintf("%+v", sc)})
+		if sc.ScrapeIndex > 0 {
+			indexed = append(indexed, sc)
+		} else {
+			scrape = sc
+		}
+	}
+
+	return scrape, indexed, nil
+}
+
+// getScrapes sets the options of base and returns it, when it is valid, followed by a copy of base
+// for each scrapePort.N parameter returned by get. The copies use the metricsPath.N and
+// scrapeInterval.N parameters when they are set.
+// Scrapes whose job name is already used by another service, e.g. the service [SERVICE]_1, are rejected.
+func (s *serve) getScrapes(base prometheus.Scrape, get func(string) string) ([]prometheus.Scrape, error) {
+	if err := getScrapeOptions(&base, get); err != nil {
+		return nil, err
+	}
+	scrapes := []prometheus.Scrape{}
+	if s.isValidScrape(&base) {
+		if base.ScrapePort == 0 && (base.ScrapeType == "static_configs" || len(base.NodeInfo) > 0) {
+			return nil, fmt.Errorf("scrapePort is required unless targets are discovered through SRV records")
+		}
+		scrapes = append(scrapes, base)
+	}
+	for i := 1; i <= 10; i++ {
+		name := fmt.Sprintf("scrapePort.%d", i)
+		value := get(name)
+		if len(value) == 0 {
+			break
+		}
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 {
+			return nil, fmt.Errorf("%s must be a positive number", name)
+		}
+		scrape := base
+		scrape.ScrapeIndex = i
+		scrape.ScrapePort = port
+		if metricsPath := get(fmt.Sprintf("metricsPath.%d", i)); len(metricsPath) > 0 {
+			scrape.MetricsPath = metricsPath
+		}
+		if scrapeInterval := get(fmt.Sprintf("scrapeInterval.%d", i)); len(scrapeInterval) > 0 {
+			scrape.ScrapeInterval = scrapeInterval
+		}
+		scrapes = append(scrapes, scrape)
+	}
+	for _, sc := range scrapes {
+		if existing, ok := s.scrapes[sc.JobName()]; ok && existing.ServiceName != sc.ServiceName {
+			return nil, fmt.Errorf("the job %s is already used by the service %s", sc.JobName(), existing.ServiceName)
+		}
+	}
+	return scrapes, nil
+}
+
+// deleteScrapes removes all scrapes of the service and returns them ordered by job name
+func (s *serve) deleteScrapes(serviceName string) []prometheus.Scrape {
+	scrapes := []prometheus.Scrape{}
+	for jobName, scrape := range s.scrapes {
+		if scrape.ServiceName == serviceName {
+			scrapes = append(scrapes, scrape)
+			delete(s.scrapes, jobName)
+		}
+	}
+	sort.Slice(scrapes, func(i, j int) bool {
+		return scrapes[i].JobName() < scrapes[j].JobName()
+	})
+	return scrapes
+}
+
+// getScrapeOptions validates the options of scrape and sets those that are not decoded
+// from a form, like honor labels, sample limit, params and relabel configs, from get
+func getScrapeOptions(scrape *prometheus.Scrape, get func(string) string) error {
+	if err := validateScrapeOptions(scrape); err != nil {
+		return err
+	}
+	if value := get("scrapeHonorLabels"); len(value) > 0 {
+		honorLabels, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("scrapeHonorLabels must be true or false")
+		}
+		scrape.ScrapeHonorLabels = honorLabels
+	}
+	if value := get("scrapeSampleLimit"); len(value) > 0 {
+		sampleLimit, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return fmt.Errorf("scrapeSampleLimit must be a positive number")
+		}
+		scrape.ScrapeSampleLimit = uint(sampleLimit)
+	}
+	if value := get("scrapeDnsNames"); len(value) > 0 {
+		scrape.ScrapeDNSNames = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) == 0 {
+				return fmt.Errorf("scrapeDnsNames must be a comma separated list of names")
+			}
+			scrape.ScrapeDNSNames = append(scrape.ScrapeDNSNames, name)
+		}
+	}
+	if value := get("scrapeParams"); len(value) > 0 {
+		scrape.ScrapeParams = map[string][]string{}
+		for _, param := range strings.Split(value, ",") {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 || len(kv[0]) == 0 {
+				return fmt.Errorf("scrapeParams must be a comma separated list of key=value pairs")
+			}
+			scrape.ScrapeParams[kv[0]] = append(scrape.ScrapeParams[kv[0]], kv[1])
+		}
+	}
+	var err error
+	scrape.RelabelConfigs, scrape.MetricRelabelConfigs, err = getRelabelConfigs(get)
+	return err
+}
+
+var durationRegex = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+$`)
+var durationPartRegex = regexp.MustCompile(`([0-9]+)(ms|s|m|h|d|w|y)`)
+
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// parseDuration converts a Prometheus duration, e.g. 1d12h, into a time.Duration
+func parseDuration(value string) (time.Duration, error) {
+	if !durationRegex.MatchString(value) {
+		return 0, fmt.Errorf("%s is not a valid duration", value)
+	}
+	d := time.Duration(0)
+	for _, part := range durationPartRegex.FindAllStringSubmatch(value, -1) {
+		n, err := strconv.ParseInt(part[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s is not a valid duration", value)
+		}
+		d += time.Duration(n) * durationUnits[part[2]]
+	}
+	return d, nil
+}
+
+// validateScrapeOptions checks the DNS options, the address family, the scheme, the authentication, the proxy URL
+// and that secrets exist in prometheus.SecretsDir
+func validateScrapeOptions(scrape *prometheus.Scrape) error {
+	if len(scrape.ScrapeScheme) > 0 && scrape.ScrapeScheme != "http" && scrape.ScrapeScheme != "https" {
+		return fmt.Errorf("scrapeScheme must be http or https")
+	}
+	if len(scrape.ScrapeDNSType) > 0 {
+		scrape.ScrapeDNSType = strings.ToUpper(scrape.ScrapeDNSType)
+		if scrape.ScrapeDNSType != "A" && scrape.ScrapeDNSType != "AAAA" && scrape.ScrapeDNSType != "SRV" {
+			return fmt.Errorf("scrapeDnsType must be A, AAAA or SRV")
+		}
+	}
+	if len(scrape.ScrapeAddressFamily) > 0 {
+		scrape.ScrapeAddressFamily = strings.ToLower(scrape.ScrapeAddressFamily)
+		if scrape.ScrapeAddressFamily != "ipv4" && scrape.ScrapeAddressFamily != "ipv6" {
+			return fmt.Errorf("scrapeAddressFamily must be ipv4 or ipv6")
+		}
+	}
+	if len(scrape.ScrapeDNSRefreshInterval) > 0 && !durationRegex.MatchString(scrape.ScrapeDNSRefreshInterval) {
+		return fmt.Errorf("scrapeDnsRefreshInterval %s is not a valid duration", scrape.ScrapeDNSRefreshInterval)
+	}
+	if len(scrape.ScrapeBasicAuthPasswordSecret) > 0 && len(scrape.ScrapeBasicAuthUsername) == 0 {
+		return fmt.Errorf("scrapeBasicAuthUsername is required when scrapeBasicAuthPasswordSecret is set")
+	}
+	if len(scrape.ScrapeBasicAuthUsername) > 0 && len(scrape.ScrapeBearerTokenSecret) > 0 {
+		return fmt.Errorf("scrapeBasicAuthUsername and scrapeBearerTokenSecret cannot be set together")
+	}
+	if (len(scrape.ScrapeTLSCertSecret) > 0) != (len(scrape.ScrapeTLSKeySecret) > 0) {
+		return fmt.Errorf("scrapeTlsCertSecret and scrapeTlsKeySecret must be set together")
+	}
+	if len(scrape.ScrapeProxyURL) > 0 {
+		if u, err := url.Parse(scrape.ScrapeProxyURL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fmt.Errorf("scrapeProxyUrl %s is not a valid URL", scrape.ScrapeProxyURL)
+		}
+	}
+	secrets := []struct {
+		param string
+		name  string
+	}{
+		{"scrapeBasicAuthPasswordSecret", scrape.ScrapeBasicAuthPasswordSecret},
+		{"scrapeBearerTokenSecret", scrape.ScrapeBearerTokenSecret},
+		{"scrapeTlsCaSecret", scrape.ScrapeTLSCASecret},
+		{"scrapeTlsCertSecret", scrape.ScrapeTLSCertSecret},
+		{"scrapeTlsKeySecret", scrape.ScrapeTLSKeySecret},
+	}
+	for _, secret := range secrets {
+		if len(secret.name) == 0 {
+			continue
+		}
+		if strings.ContainsAny(secret.name, "/\\") || secret.name == "." || secret.name == ".." {
+			return fmt.Errorf("%s must be the name of a secret, not a path", secret.param)
+		}
+		path := fmt.Sprintf("%s/%s", prometheus.SecretsDir, secret.name)
+		if exists, _ := afero.Exists(FS, path); !exists {
+			return fmt.Errorf("%s %s does not exist", secret.param, path)
+		}
+	}
+	return nil
+}
 
-	return scrape
+// getRelabelConfigs parses the relabel.N and metricRelabel.N parameters returned by get
+func getRelabelConfigs(get func(string) string) ([]*prometheus.RelabelConfig, []*prometheus.RelabelConfig, error) {
+	configs := [][]*prometheus.RelabelConfig{nil, nil}
+	for i, param := range []string{"relabel", "metricRelabel"} {
+		for j := 1; j <= 10; j++ {
+			name := fmt.Sprintf("%s.%d", param, j)
+			value := get(name)
+			if len(value) == 0 {
+				break
+			}
+			rc, err := prometheus.ParseRelabelConfig(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s is not valid: %s", name, err.Error())
+			}
+			configs[i] = append(configs[i], rc)
+		}
+	}
+	return configs[0], configs[1], nil
 }
 
+// isValidScrape returns true when the scrape has a service name and a port.
+// SRV records contain the port, so it is optional for them.
 func (s *serve) isValidScrape(scrape *prometheus.Scrape) bool {
-	return len(scrape.ServiceName) > 0 && scrape.ScrapePort > 0
+	if len(scrape.ServiceName) == 0 {
+		return false
+	}
+	return scrape.ScrapePort > 0 || strings.EqualFold(scrape.ScrapeDNSType, "SRV")
 }
 
 func (s *serve) getResponse(alerts *[]prometheus.Alert, scrape *prometheus.Scrape, err error, statusCode int) response {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"../logging"
 	"../prometheus"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/suite"
@@ -46,18 +48,18 @@ func (s *ServerTestSuite) TearDownTest() {
 
 func TestServerUnitTestSuite(t *testing.T) {
 	s := new(ServerTestSuite)
-	logPrintlnOrig := logPrintf
+	loggerOrig := logger
 	listenerTimeoutOrig := listenerTimeout
 
 	fsOrig := FS
 	defer func() {
-		logPrintf = logPrintlnOrig
+		logger = loggerOrig
 		listenerTimeout = listenerTimeoutOrig
 		FS = fsOrig
 	}()
 
 	listenerTimeout = 10 * time.Millisecond
-	logPrintf = func(format string, v ...interface{}) {}
+	logger = logging.New(ioutil.Discard, "", logging.InfoLevel)
 	FS = afero.NewMemMapFs()
 
 	// move ../confg/shortcuts.yaml file to `shortcutsPath`
@@ -72,6 +74,7 @@ func TestServerUnitTestSuite(t *testing.T) {
 	os.Setenv("ARG_WEB_CONSOLE_LIBRARIES", "/usr/share/prometheus/console_libraries")
 	os.Setenv("ARG_WEB_CONSOLE_TEMPLATES", "/usr/share/prometheus/consoles")
 	os.Setenv("ARG_ALERTMANAGER_URL", "http://alert-manager:9093")
+	os.Setenv("DF_TARGET_DOWN_ALERT", "false")
 
 	suite.Run(t, s)
 }
@@ -128,6 +131,51 @@ func (s *ServerTestSuite) Test_Execute_ReturnsError_WhenHTTPListenAndServeFails(
 	s.Error(actual)
 }
 
+func (s *ServerTestSuite) Test_Execute_ReturnsError_WhenEnvIsInvalidAndConfigIsStrict() {
+	orig := httpListenAndServe
+	defer func() {
+		httpListenAndServe = orig
+		os.Unsetenv("DF_STRICT_CONFIG")
+		os.Unsetenv("GLOBAL__SCRAPE_INTERVALS")
+	}()
+	listenCalled := false
+	httpListenAndServe = func(addr string, handler http.Handler) error {
+		listenCalled = true
+		return nil
+	}
+	os.Setenv("DF_STRICT_CONFIG", "true")
+	os.Setenv("GLOBAL__SCRAPE_INTERVALS", "10s")
+
+	serve := New()
+	actual := serve.Execute()
+
+	s.Error(actual)
+	s.False(listenCalled)
+}
+
+func (s *ServerTestSuite) Test_Execute_Starts_WhenEnvIsInvalidAndConfigIsNotStrict() {
+	orig := httpListenAndServe
+	defer func() {
+		httpListenAndServe = orig
+		os.Unsetenv("GLOBAL__SCRAPE_INTERVALS")
+	}()
+	fsOrig := prometheus.FS
+	defer func() { prometheus.FS = fsOrig }()
+	prometheus.FS = afero.NewMemMapFs()
+	listenCalled := false
+	httpListenAndServe = func(addr string, handler http.Handler) error {
+		listenCalled = true
+		return nil
+	}
+	os.Setenv("GLOBAL__SCRAPE_INTERVALS", "10s")
+
+	serve := New()
+	actual := serve.Execute()
+
+	s.NoError(actual)
+	s.True(listenCalled)
+}
+
 func (s *ServerTestSuite) Test_Execute_WritesConfig() {
 	expected := `global:
   scrape_interval: 5s
@@ -858,6 +906,292 @@ func (s *ServerTestSuite) Test_ReconfigureHandler_WithNodeInfo_NoTargetLabelsDef
 	s.True(expected.NodeInfo.Equal(targetScrape.NodeInfo))
 }
 
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsSchemeAuthAndTLS() {
+	for _, secret := range []string{"my-password", "ca.pem", "cert.pem", "key.pem"} {
+		afero.WriteFile(FS, "/run/secrets/"+secret, []byte("secret"), 0644)
+		defer FS.Remove("/run/secrets/" + secret)
+	}
+	addr := "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234" +
+		"&scrapeScheme=https&scrapeBasicAuthUsername=admin&scrapeBasicAuthPasswordSecret=my-password" +
+		"&scrapeTlsCaSecret=ca.pem&scrapeTlsCertSecret=cert.pem&scrapeTlsKeySecret=key.pem" +
+		"&scrapeTlsServerName=my-service.example.com&scrapeProxyUrl=http://proxy:3128"
+	req, _ := http.NewRequest("GET", addr, nil)
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	s.Equal(prometheus.Scrape{
+		ServiceName:                   "my-service",
+		ScrapePort:                    1234,
+		ScrapeScheme:                  "https",
+		ScrapeBasicAuthUsername:       "admin",
+		ScrapeBasicAuthPasswordSecret: "my-password",
+		ScrapeTLSCASecret:             "ca.pem",
+		ScrapeTLSCertSecret:           "cert.pem",
+		ScrapeTLSKeySecret:            "key.pem",
+		ScrapeTLSServerName:           "my-service.example.com",
+		ScrapeProxyURL:                "http://proxy:3128",
+	}, serve.scrapes["my-service"])
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenScrapeOptionsAreInvalid() {
+	afero.WriteFile(FS, "/run/secrets/my-token", []byte("secret"), 0644)
+	defer FS.Remove("/run/secrets/my-token")
+	for _, params := range []string{
+		"scrapeScheme=ftp",
+		"scrapeBasicAuthPasswordSecret=my-token",
+		"scrapeBearerTokenSecret=does-not-exist",
+		"scrapeBearerTokenSecret=../secrets/my-token",
+		"scrapeBasicAuthUsername=admin&scrapeBearerTokenSecret=my-token",
+		"scrapeTlsCertSecret=my-token",
+		"scrapeProxyUrl=proxy",
+	} {
+		actual := response{}
+		rwMock := ResponseWriterMock{
+			WriteMock: func(content []byte) (int, error) {
+				json.Unmarshal(content, &actual)
+				return 0, nil
+			},
+		}
+		req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&"+params, nil)
+
+		serve := New()
+		serve.ReconfigureHandler(rwMock, req)
+
+		s.Equal(http.StatusBadRequest, actual.Status, params)
+		s.Empty(serve.scrapes, params)
+	}
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsSRVScrape_WithoutPort() {
+	addr := "/v1/docker-flow-monitor/reconfigure?serviceName=my-service" +
+		"&scrapeDnsType=srv&scrapeDnsNames=_metrics._tcp.my-service,_metrics._tcp.alias&scrapeDnsRefreshInterval=1m30s"
+	req, _ := http.NewRequest("GET", addr, nil)
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	s.Equal(prometheus.Scrape{
+		ServiceName:              "my-service",
+		ScrapeDNSType:            "SRV",
+		ScrapeDNSNames:           []string{"_metrics._tcp.my-service", "_metrics._tcp.alias"},
+		ScrapeDNSRefreshInterval: "1m30s",
+	}, serve.scrapes["my-service"])
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenDNSOptionsAreInvalid() {
+	for _, params := range []string{
+		"scrapePort=1234&scrapeDnsType=CNAME",
+		"scrapePort=1234&scrapeDnsNames=a,,b",
+		"scrapePort=1234&scrapeDnsRefreshInterval=soon",
+		"scrapeDnsType=SRV&scrapeType=static_configs",
+		`scrapeDnsType=SRV&nodeInfo=[["node-1","1.0.0.1"]]`,
+	} {
+		actual := 0
+		rwMock := ResponseWriterMock{
+			WriteHeaderMock: func(status int) {
+				actual = status
+			},
+		}
+		req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&"+params, nil)
+
+		serve := New()
+		serve.ReconfigureHandler(rwMock, req)
+
+		s.Equal(http.StatusBadRequest, actual, params)
+	}
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsIndexedScrapes() {
+	actual := response{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	addr := "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=8080&scrapeScheme=https" +
+		"&scrapePort.1=9404&metricsPath.1=/&scrapeInterval.1=30s&scrapePort.2=9100"
+	req, _ := http.NewRequest("GET", addr, nil)
+
+	serve := New()
+	serve.ReconfigureHandler(rwMock, req)
+
+	s.Equal(map[string]prometheus.Scrape{
+		"my-service":   {ServiceName: "my-service", ScrapePort: 8080, ScrapeScheme: "https"},
+		"my-service_1": {ServiceName: "my-service", ScrapePort: 9404, ScrapeScheme: "https", MetricsPath: "/", ScrapeInterval: "30s", ScrapeIndex: 1},
+		"my-service_2": {ServiceName: "my-service", ScrapePort: 9100, ScrapeScheme: "https", ScrapeIndex: 2},
+	}, serve.scrapes)
+	s.Equal(8080, actual.ScrapePort)
+	s.Require().Len(actual.Scrapes, 2)
+	s.Equal(9404, actual.Scrapes[0].ScrapePort)
+	s.Equal(9100, actual.Scrapes[1].ScrapePort)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsIndexedScrapes_WithoutScrapePort() {
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort.1=9404", nil)
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	s.Equal(map[string]prometheus.Scrape{
+		"my-service_1": {ServiceName: "my-service", ScrapePort: 9404, ScrapeIndex: 1},
+	}, serve.scrapes)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReplacesIndexedScrapes() {
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=8080&scrapePort.1=9404&scrapePort.2=9100")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=8080&scrapePort.1=9405")
+
+	s.Len(serve.scrapes, 2)
+	s.Equal(9405, serve.scrapes["my-service_1"].ScrapePort)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenJobNameBelongsToAnotherService() {
+	for _, addrs := range [][]string{
+		{"serviceName=my-service_1&scrapePort=8080", "serviceName=my-service&scrapePort=8080&scrapePort.1=9404"},
+		{"serviceName=my-service&scrapePort=8080&scrapePort.1=9404", "serviceName=my-service_1&scrapePort=8080"},
+	} {
+		serve := New()
+		s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?"+addrs[0])
+		expected := map[string]prometheus.Scrape{}
+		for k, v := range serve.scrapes {
+			expected[k] = v
+		}
+		actual := 0
+		rwMock := ResponseWriterMock{
+			WriteHeaderMock: func(status int) {
+				actual = status
+			},
+		}
+		req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?"+addrs[1], nil)
+		serve.ReconfigureHandler(rwMock, req)
+
+		s.Equal(http.StatusBadRequest, actual, addrs[1])
+		s.Equal(expected, serve.scrapes, addrs[1])
+	}
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenIndexedPortIsInvalid() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=8080&scrapePort.1=jvm", nil)
+
+	serve := New()
+	serve.ReconfigureHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual)
+	s.Empty(serve.scrapes)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsAddressFamily() {
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&scrapeAddressFamily=IPv6", nil)
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	s.Equal("ipv6", serve.scrapes["my-service"].ScrapeAddressFamily)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenAddressFamilyIsInvalid() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&scrapeAddressFamily=ipx", nil)
+
+	serve := New()
+	serve.ReconfigureHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsHonorLabelsSampleLimitAndParams() {
+	addr := "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234" +
+		"&scrapeHonorLabels=true&scrapeSampleLimit=5000&scrapeParams=module=http_2xx,target=a,target=b"
+	req, _ := http.NewRequest("GET", addr, nil)
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	scrape := serve.scrapes["my-service"]
+	s.True(scrape.ScrapeHonorLabels)
+	s.Equal(uint(5000), scrape.ScrapeSampleLimit)
+	s.Equal(map[string][]string{"module": {"http_2xx"}, "target": {"a", "b"}}, scrape.ScrapeParams)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenHonorLabelsSampleLimitOrParamsAreInvalid() {
+	for _, params := range []string{
+		"scrapeHonorLabels=maybe",
+		"scrapeSampleLimit=-1",
+		"scrapeParams=module",
+	} {
+		actual := 0
+		rwMock := ResponseWriterMock{
+			WriteHeaderMock: func(status int) {
+				actual = status
+			},
+		}
+		req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&"+params, nil)
+
+		serve := New()
+		serve.ReconfigureHandler(rwMock, req)
+
+		s.Equal(http.StatusBadRequest, actual, params)
+	}
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsRelabelConfigs() {
+	addr := "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&" + url.Values{
+		"relabel.1":       {"sourceLabels=__meta_dns_name,regex=tasks\\.(.*),targetLabel=task,replacement=$1"},
+		"metricRelabel.1": {"sourceLabels=__name__,regex=go_gc_.*,action=drop"},
+		"metricRelabel.2": {"regex=pid,action=labeldrop"},
+	}.Encode()
+	req, _ := http.NewRequest("GET", addr, nil)
+
+	serve := New()
+	serve.ReconfigureHandler(ResponseWriterMock{}, req)
+
+	scrape := serve.scrapes["my-service"]
+	s.Equal([]*prometheus.RelabelConfig{
+		{SourceLabels: []string{"__meta_dns_name"}, Regex: "tasks\\.(.*)", TargetLabel: "task", Replacement: "$1"},
+	}, scrape.RelabelConfigs)
+	s.Equal([]*prometheus.RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_gc_.*", Action: "drop"},
+		{Regex: "pid", Action: "labeldrop"},
+	}, scrape.MetricRelabelConfigs)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_ReturnsBadRequest_WhenRelabelConfigIsInvalid() {
+	actual := response{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	addr := "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if&" + url.Values{
+		"metricRelabel.1": {"sourceLabels=__name__,action=explode"},
+	}.Encode()
+	req, _ := http.NewRequest("GET", addr, nil)
+
+	serve := New()
+	serve.ReconfigureHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual.Status)
+	s.Contains(actual.Message, "metricRelabel.1 is not valid")
+	s.Empty(serve.scrapes)
+	s.Empty(serve.alerts)
+	s.Equal(0, s.reloadCalledNum)
+}
+
 func (s *ServerTestSuite) Test_ReconfigureHandler_DoesNotAddAlert_WhenAlertNameIsEmpty() {
 	rwMock := ResponseWriterMock{}
 	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor", nil)
@@ -1171,6 +1505,99 @@ func (s *ServerTestSuite) Test_PingHandler_SetsStatusCodeTo200() {
 	s.Equal(200, actual)
 }
 
+// LogLevelHandler
+
+func (s *ServerTestSuite) Test_LogLevelHandler_ChangesLevel() {
+	loggerOrig := logger
+	defer func() { logger = loggerOrig }()
+	logger = logging.New(ioutil.Discard, "", logging.InfoLevel)
+	actual := logLevelResponse{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-monitor/log-level?level=debug", nil)
+
+	serve := New()
+	serve.LogLevelHandler(rwMock, req)
+
+	s.Equal(http.StatusOK, actual.Status)
+	s.Equal("debug", actual.Level)
+	s.Equal(logging.DebugLevel, logger.Level())
+}
+
+func (s *ServerTestSuite) Test_LogLevelHandler_ReturnsBadRequest_WhenLevelIsUnknown() {
+	actual := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actual = status
+		},
+	}
+	req, _ := http.NewRequest("PUT", "/v1/docker-flow-monitor/log-level?level=verbose", nil)
+
+	serve := New()
+	serve.LogLevelHandler(rwMock, req)
+
+	s.Equal(http.StatusBadRequest, actual)
+	s.Equal(logging.InfoLevel, logger.Level())
+}
+
+// ConfigConflictsHandler
+
+func (s *ServerTestSuite) Test_ConfigConflictsHandler_ReturnsBaseConfigConflicts() {
+	fsOrig := prometheus.FS
+	defer func() {
+		os.Unsetenv("DF_BASE_CONFIG")
+		prometheus.WriteConfig("/etc/prometheus/prometheus.yml", map[string]prometheus.Scrape{}, map[string]prometheus.Alert{}, map[string]map[string]string{})
+		prometheus.FS = fsOrig
+	}()
+	prometheus.FS = afero.NewMemMapFs()
+	afero.WriteFile(prometheus.FS, "/run/secrets/prometheus_base", []byte("global:\n  scrape_interval: 30s\n"), 0644)
+	os.Setenv("DF_BASE_CONFIG", "/run/secrets/prometheus_base")
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	actual := []prometheus.ConfigConflict{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/config/conflicts", nil)
+
+	serve.ConfigConflictsHandler(rwMock, req)
+
+	s.Equal([]prometheus.ConfigConflict{
+		{Path: "global.scrape_interval", Message: "The base value 30s is used instead of the generated value 5s"},
+	}, actual)
+}
+
+// ConfigErrorsHandler
+
+func (s *ServerTestSuite) Test_ConfigErrorsHandler_ReturnsEnvErrors() {
+	defer os.Unsetenv("REMOTE_WRITE_1__QUEUE_CONFIG__CAPACITY")
+	os.Setenv("REMOTE_WRITE_1__QUEUE_CONFIG__CAPACITY", "many")
+	actual := []prometheus.EnvError{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/config/errors", nil)
+
+	serve := New()
+	serve.ConfigErrorsHandler(rwMock, req)
+
+	s.Equal([]prometheus.EnvError{{
+		Variable: "REMOTE_WRITE_1__QUEUE_CONFIG__CAPACITY",
+		Path:     "capacity",
+		Message:  "expects an integer but got many",
+	}}, actual)
+}
+
 // RemoveHandler
 
 func (s *ServerTestSuite) Test_RemoveHandler_SetsContentHeaderToJson() {
@@ -1202,6 +1629,34 @@ func (s *ServerTestSuite) Test_RemoveHandler_RemovesScrape() {
 	s.Len(serve.scrapes, 1)
 }
 
+func (s *ServerTestSuite) Test_RemoveHandler_RemovesIndexedScrapes() {
+	actual := response{}
+	rwMock := ResponseWriterMock{
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, &actual)
+			return 0, nil
+		},
+	}
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=8080&scrapePort.1=9404&scrapePort.2=9100")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort.1=9404")
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-monitor/remove?serviceName=my-service", nil)
+
+	serve.RemoveHandler(rwMock, req)
+
+	s.Equal([]string{"other-service_1"}, func() []string {
+		names := []string{}
+		for name := range serve.scrapes {
+			names = append(names, name)
+		}
+		return names
+	}())
+	s.Equal(8080, actual.ScrapePort)
+	s.Require().Len(actual.Scrapes, 2)
+	s.Equal(1, actual.Scrapes[0].ScrapeIndex)
+	s.Equal(2, actual.Scrapes[1].ScrapeIndex)
+}
+
 func (s *ServerTestSuite) Test_RemoveHandler_RemovesAlerts() {
 	rwMock := ResponseWriterMock{}
 	addr := "/v1/docker-flow-monitor?serviceName=my-service-1"
@@ -1582,6 +2037,38 @@ func (s *ServerTestSuite) Test_InitialConfig_AddsScrapes() {
 	s.Equal(expected, serve.scrapes)
 }
 
+func (s *ServerTestSuite) Test_InitialConfig_AddsScrapeOptions() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		resp := []map[string]string{
+			{"scrapePort": "1111", "serviceName": "service-1", "metricRelabel.1": "sourceLabels=__name__,regex=go_gc_.*,action=drop", "scrapeHonorLabels": "true", "scrapeSampleLimit": "100", "scrapeParams": "a=b"},
+			{"scrapePort": "2222", "serviceName": "service-2", "metricRelabel.1": "action=explode"},
+			{"serviceName": "service-4", "scrapePort.1": "9404", "metricsPath.1": "/jvm"},
+			{"serviceName": "service-3", "scrapeDnsType": "SRV", "scrapeDnsNames": "_metrics._tcp.service-3", "scrapeDnsRefreshInterval": "1m"},
+		}
+		js, _ := json.Marshal(resp)
+		w.Write(js)
+	}))
+	defer testServer.Close()
+	defer func() { os.Unsetenv("LISTENER_ADDRESS") }()
+	os.Setenv("LISTENER_ADDRESS", testServer.URL)
+
+	serve := New()
+	serve.InitialConfig()
+
+	s.Require().Len(serve.scrapes, 3)
+	s.Equal(prometheus.Scrape{ServiceName: "service-4", ScrapePort: 9404, MetricsPath: "/jvm", ScrapeIndex: 1}, serve.scrapes["service-4_1"])
+	s.Equal([]*prometheus.RelabelConfig{
+		{SourceLabels: []string{"__name__"}, Regex: "go_gc_.*", Action: "drop"},
+	}, serve.scrapes["service-1"].MetricRelabelConfigs)
+	s.True(serve.scrapes["service-1"].ScrapeHonorLabels)
+	s.Equal(uint(100), serve.scrapes["service-1"].ScrapeSampleLimit)
+	s.Equal(map[string][]string{"a": {"b"}}, serve.scrapes["service-1"].ScrapeParams)
+	s.Equal("SRV", serve.scrapes["service-3"].ScrapeDNSType)
+	s.Equal([]string{"_metrics._tcp.service-3"}, serve.scrapes["service-3"].ScrapeDNSNames)
+	s.Equal("1m", serve.scrapes["service-3"].ScrapeDNSRefreshInterval)
+}
+
 func (s *ServerTestSuite) Test_InitialConfig_AddsScrapesFromEnv() {
 	expected := map[string]prometheus.Scrape{
 		"service-1": {ServiceName: "service-1", ScrapePort: 1111},
@@ -1922,8 +2409,111 @@ func (s *ServerTestSuite) Test_RemoveNodeHandler_RemoveNodes() {
 	s.Len(serve.nodeLabels, 0)
 }
 
+// inheritLabels
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsInheritedLabelsToAlerts() {
+	defer os.Unsetenv("DF_ALERT_INHERIT_LABELS")
+	os.Setenv("DF_ALERT_INHERIT_LABELS", "team, env,missing")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&team=payments&env=prod"+
+		"&alertName=memlimit&alertIf=@service_mem_limit:0.8"+
+		"&alertName.1=errors&alertIf.1=a>b&alertLabels.1=team=platform")
+
+	s.Equal(map[string]string{"receiver": "system", "service": "my-service", "team": "payments", "env": "prod"}, serve.alerts["myservice_memlimit"].AlertLabels)
+	s.Equal(map[string]string{"team": "platform", "env": "prod"}, serve.alerts["myservice_errors"].AlertLabels)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_SkipsInheritedLabels_WhenNameIsInvalid() {
+	defer os.Unsetenv("DF_ALERT_INHERIT_LABELS")
+	os.Setenv("DF_ALERT_INHERIT_LABELS", "team-name,com.df.team,1team,team")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service"+
+		"&team-name=payments&com.df.team=payments&1team=payments&team=payments&alertName=errors&alertIf=a>b")
+
+	s.Equal(map[string]string{"team": "payments"}, serve.alerts["myservice_errors"].AlertLabels)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsInheritedLabelsToGeneratedAlerts() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	defer os.Unsetenv("DF_ALERT_INHERIT_LABELS")
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	os.Setenv("DF_ALERT_INHERIT_LABELS", "team")
+	s.writePolicies(testPolicies)
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&team=payments")
+
+	s.Equal("payments", serve.alerts["myservice_targetdown"].AlertLabels["team"])
+	s.Equal("payments", serve.alerts["myservice_memlimit"].AlertLabels["team"])
+	s.Equal("payments", serve.alerts["myservice_errors"].AlertLabels["receiver"])
+}
+
+func (s *ServerTestSuite) Test_InitialConfig_AddsInheritedLabelsToAlerts() {
+	defer os.Unsetenv("DF_ALERT_INHERIT_LABELS")
+	os.Setenv("DF_ALERT_INHERIT_LABELS", "team")
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		js, _ := json.Marshal([]map[string]string{
+			{"serviceName": "my-service", "team": "payments", "alertName": "errors", "alertIf": "a > b"},
+		})
+		w.Write(js)
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("LISTENER_ADDRESS")
+	os.Setenv("LISTENER_ADDRESS", testServer.URL)
+	serve := New()
+
+	serve.InitialConfig()
+
+	s.Equal(map[string]string{"team": "payments"}, serve.alerts["myservice_errors"].AlertLabels)
+}
+
+// parseDuration
+
+func (s *ServerTestSuite) Test_ParseDuration_ConvertsPrometheusDurations() {
+	testData := map[string]time.Duration{
+		"500ms": 500 * time.Millisecond,
+		"1m30s": 90 * time.Second,
+		"1d12h": 36 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+		"1y":    365 * 24 * time.Hour,
+	}
+	for value, expected := range testData {
+		actual, err := parseDuration(value)
+
+		s.NoError(err, value)
+		s.Equal(expected, actual, value)
+	}
+}
+
+func (s *ServerTestSuite) Test_ParseDuration_ReturnsError_WhenDurationIsInvalid() {
+	for _, value := range []string{"", "later", "1.5h", "-1m", "1h "} {
+		_, err := parseDuration(value)
+
+		s.Error(err, value)
+	}
+}
+
 // Mock
 
+// serveJSON sends a GET request to addr through the handler, decodes the response into actual and returns the status
+func (s *ServerTestSuite) serveJSON(handler http.HandlerFunc, addr string, actual interface{}) int {
+	actualStatus := 0
+	rwMock := ResponseWriterMock{
+		WriteHeaderMock: func(status int) {
+			actualStatus = status
+		},
+		WriteMock: func(content []byte) (int, error) {
+			json.Unmarshal(content, actual)
+			return 0, nil
+		},
+	}
+	req, _ := http.NewRequest("GET", addr, nil)
+	handler(rwMock, req)
+	return actualStatus
+}
+
 type ResponseWriterMock struct {
 	HeaderMock      func() http.Header
 	WriteMock       func([]byte) (int, error)
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+
+	"../prometheus"
+)
+
+// serveState is a copy of the scrapes, alerts and node labels registered with serve
+type serveState struct {
+	scrapes    map[string]prometheus.Scrape
+	alerts     map[string]prometheus.Alert
+	nodeLabels map[string]map[string]string
+}
+
+// stateChange lists the keys that were added, updated or removed between two states
+type stateChange struct {
+	AddedScrapes   []string
+	UpdatedScrapes []string
+	RemovedScrapes []string
+	AddedAlerts    []string
+	UpdatedAlerts  []string
+	RemovedAlerts  []string
+	AddedNodes     []string
+	UpdatedNodes   []string
+	RemovedNodes   []string
+}
+
+// snapshot returns a copy of the current state.
+// Values are never modified in place, so copying the maps is enough.
+func (s *serve) snapshot() serveState {
+	return serveState{
+		scrapes:    copyScrapes(s.scrapes),
+		alerts:     copyAlerts(s.alerts),
+		nodeLabels: copyNodeLabels(s.nodeLabels),
+	}
+}
+
+func (s *serve) restore(state serveState) {
+	s.scrapes = copyScrapes(state.scrapes)
+	s.alerts = copyAlerts(state.alerts)
+	s.nodeLabels = copyNodeLabels(state.nodeLabels)
+}
+
+// changesSince compares the current state with before
+func (s *serve) changesSince(before serveState) stateChange {
+	c := stateChange{}
+	c.AddedScrapes, c.UpdatedScrapes, c.RemovedScrapes = diffKeys(before.scrapes, s.scrapes)
+	c.AddedAlerts, c.UpdatedAlerts, c.RemovedAlerts = diffKeys(before.alerts, s.alerts)
+	c.AddedNodes, c.UpdatedNodes, c.RemovedNodes = diffKeys(before.nodeLabels, s.nodeLabels)
+	return c
+}
+
+// isEmpty returns true when nothing changed
+func (c stateChange) isEmpty() bool {
+	return len(c.AddedScrapes)+len(c.UpdatedScrapes)+len(c.RemovedScrapes)+
+		len(c.AddedAlerts)+len(c.UpdatedAlerts)+len(c.RemovedAlerts)+
+		len(c.AddedNodes)+len(c.UpdatedNodes)+len(c.RemovedNodes) == 0
+}
+
+// diffKeys returns sorted keys of maps before and after that were added, updated or removed
+func diffKeys(before, after interface{}) (added, updated, removed []string) {
+	b := reflect.ValueOf(before)
+	a := reflect.ValueOf(after)
+	for _, k := range a.MapKeys() {
+		bv := b.MapIndex(k)
+		if !bv.IsValid() {
+			added = append(added, k.String())
+		} else if !reflect.DeepEqual(bv.Interface(), a.MapIndex(k).Interface()) {
+			updated = append(updated, k.String())
+		}
+	}
+	for _, k := range b.MapKeys() {
+		if !a.MapIndex(k).IsValid() {
+			removed = append(removed, k.String())
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(updated)
+	sort.Strings(removed)
+	return added, updated, removed
+}
+
+func copyScrapes(scrapes map[string]prometheus.Scrape) map[string]prometheus.Scrape {
+	c := make(map[string]prometheus.Scrape, len(scrapes))
+	for k, v := range scrapes {
+		c[k] = v
+	}
+	return c
+}
+
+func copyAlerts(alerts map[string]prometheus.Alert) map[string]prometheus.Alert {
+	c := make(map[string]prometheus.Alert, len(alerts))
+	for k, v := range alerts {
+		c[k] = v
+	}
+	return c
+}
+
+func copyNodeLabels(nodeLabels map[string]map[string]string) map[string]map[string]string {
+	c := make(map[string]map[string]string, len(nodeLabels))
+	for k, v := range nodeLabels {
+		c[k] = v
+	}
+	return c
+}
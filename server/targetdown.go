@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"../logging"
+	"../prometheus"
+)
+
+const targetDownAlertName = "targetdown"
+
+var defaultTargetDownAlertFor = "1m"
+
+// addTargetDownAlerts adds an alert for each scrape of the service that fires when one of its targets
+// cannot be scraped. The alerts are configured through the DF_TARGET_DOWN_ALERT* environment variables
+// and can be overridden per service through the targetDownAlert* options returned by get.
+// Durations that are not valid are ignored so that a bad value does not break the rules of all services.
+// Alerts with the same name that are already registered, e.g. sent explicitly in the same request, are kept.
+func (s *serve) addTargetDownAlerts(serviceName string, get func(string) string) []prometheus.Alert {
+	alerts := []prometheus.Alert{}
+	enabled := !strings.EqualFold(os.Getenv("DF_TARGET_DOWN_ALERT"), "false")
+	if value := get("targetDownAlert"); len(value) > 0 {
+		enabled = !strings.EqualFold(value, "false")
+	}
+	if !enabled {
+		return alerts
+	}
+	alertFor := defaultTargetDownAlertFor
+	for _, option := range []struct {
+		name  string
+		value string
+	}{
+		{"DF_TARGET_DOWN_ALERT_FOR", os.Getenv("DF_TARGET_DOWN_ALERT_FOR")},
+		{"targetDownAlertFor", get("targetDownAlertFor")},
+	} {
+		if len(option.value) == 0 {
+			continue
+		}
+		if !durationRegex.MatchString(option.value) {
+			logger.Warn("Ignoring invalid target down alert duration", logging.Fields{"service": serviceName, "option": option.name, "value": option.value})
+			continue
+		}
+		alertFor = option.value
+	}
+	labels := map[string]string{"receiver": "system", "service": serviceName}
+	annotations := map[string]string{
+		"summary": fmt.Sprintf("Target {{ $labels.instance }} of the service %s is down", serviceName),
+	}
+	for _, m := range []map[string]string{
+		s.getMapFromString(os.Getenv("DF_TARGET_DOWN_ALERT_LABELS")),
+		s.getMapFromString(get("targetDownAlertLabels")),
+	} {
+		for k, v := range m {
+			labels[k] = v
+		}
+	}
+	for _, m := range []map[string]string{
+		s.getMapFromString(os.Getenv("DF_TARGET_DOWN_ALERT_ANNOTATIONS")),
+		s.getMapFromString(get("targetDownAlertAnnotations")),
+	} {
+		for k, v := range m {
+			annotations[k] = v
+		}
+	}
+
+	jobs := []prometheus.Scrape{}
+	for _, sc := range s.scrapes {
+		if sc.ServiceName == serviceName {
+			jobs = append(jobs, sc)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ScrapeIndex < jobs[j].ScrapeIndex
+	})
+	for _, sc := range jobs {
+		alert := prometheus.Alert{
+			ServiceName:      serviceName,
+			AlertName:        targetDownAlertName,
+			AlertIf:          fmt.Sprintf(`up{job="%s"} == 0`, sc.JobName()),
+			AlertFor:         alertFor,
+			AlertLabels:      copyStringMap(labels),
+			AlertAnnotations: copyStringMap(annotations),
+		}
+		if sc.ScrapeIndex > 0 {
+			alert.AlertName = fmt.Sprintf("%s_%d", targetDownAlertName, sc.ScrapeIndex)
+		}
+		s.formatAlert(&alert)
+		inheritLabels(&alert, get)
+		if _, ok := s.alerts[alert.AlertNameFormatted]; ok {
+			continue
+		}
+		s.alerts[alert.AlertNameFormatted] = alert
+		logAlert(&alert)
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
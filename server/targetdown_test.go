@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"os"
+
+	"../prometheus"
+)
+
+// addTargetDownAlerts
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsTargetDownAlert() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&scrapePort.1=5678")
+
+	s.Equal(prometheus.Alert{
+		ServiceName:        "my-service",
+		AlertName:          "targetdown",
+		AlertNameFormatted: "myservice_targetdown",
+		AlertIf:            `up{job="my-service"} == 0`,
+		AlertFor:           "1m",
+		AlertLabels:        map[string]string{"receiver": "system", "service": "my-service"},
+		AlertAnnotations:   map[string]string{"summary": "Target {{ $labels.instance }} of the service my-service is down"},
+	}, serve.alerts["myservice_targetdown"])
+	s.Equal(`up{job="my-service_1"} == 0`, serve.alerts["myservice_targetdown_1"].AlertIf)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_AddsTargetDownAlert_WithEnvAndServiceOptions() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	defer os.Unsetenv("DF_TARGET_DOWN_ALERT_FOR")
+	defer os.Unsetenv("DF_TARGET_DOWN_ALERT_LABELS")
+	defer os.Unsetenv("DF_TARGET_DOWN_ALERT_ANNOTATIONS")
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	os.Setenv("DF_TARGET_DOWN_ALERT_FOR", "5m")
+	os.Setenv("DF_TARGET_DOWN_ALERT_LABELS", "receiver=ops,severity=warning")
+	os.Setenv("DF_TARGET_DOWN_ALERT_ANNOTATIONS", "runbook=http://wiki/target-down")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&targetDownAlertFor=10m&targetDownAlertLabels=severity=critical")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=1234")
+
+	alert := serve.alerts["myservice_targetdown"]
+	s.Equal("10m", alert.AlertFor)
+	s.Equal(map[string]string{"receiver": "ops", "service": "my-service", "severity": "critical"}, alert.AlertLabels)
+	s.Equal("http://wiki/target-down", alert.AlertAnnotations["runbook"])
+	s.Contains(alert.AlertAnnotations, "summary")
+	alert = serve.alerts["otherservice_targetdown"]
+	s.Equal("5m", alert.AlertFor)
+	s.Equal("warning", alert.AlertLabels["severity"])
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_IgnoresInvalidTargetDownAlertFor() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	defer os.Unsetenv("DF_TARGET_DOWN_ALERT_FOR")
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	os.Setenv("DF_TARGET_DOWN_ALERT_FOR", "five minutes")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=1234&targetDownAlertFor=2x")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=third-service&scrapePort=1234&targetDownAlertFor=1h30m")
+
+	s.Equal("1m", serve.alerts["myservice_targetdown"].AlertFor)
+	s.Equal("1m", serve.alerts["otherservice_targetdown"].AlertFor)
+	s.Equal("1h30m", serve.alerts["thirdservice_targetdown"].AlertFor)
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_DoesNotAddTargetDownAlert_WhenDisabled() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&targetDownAlert=false")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=no-scrape&alertName=mem&alertIf=a>b")
+
+	s.Len(serve.alerts, 1)
+	s.Contains(serve.alerts, "noscrape_mem")
+
+	os.Setenv("DF_TARGET_DOWN_ALERT", "false")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=1234")
+
+	s.NotContains(serve.alerts, "otherservice_targetdown")
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=1234&targetDownAlert=true")
+
+	s.Contains(serve.alerts, "otherservice_targetdown")
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_KeepsExplicitTargetDownAlert() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	serve := New()
+
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=targetdown&alertIf=up==0&alertFor=30s")
+
+	s.Equal("up==0", serve.alerts["myservice_targetdown"].AlertIf)
+	s.Equal("30s", serve.alerts["myservice_targetdown"].AlertFor)
+}
+
+func (s *ServerTestSuite) Test_RemoveHandler_RemovesTargetDownAlert() {
+	defer os.Setenv("DF_TARGET_DOWN_ALERT", os.Getenv("DF_TARGET_DOWN_ALERT"))
+	os.Unsetenv("DF_TARGET_DOWN_ALERT")
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	s.Require().Contains(serve.alerts, "myservice_targetdown")
+	req, _ := http.NewRequest("GET", "/v1/docker-flow-monitor/remove?serviceName=my-service", nil)
+
+	serve.RemoveHandler(ResponseWriterMock{}, req)
+
+	s.Empty(serve.alerts)
+}
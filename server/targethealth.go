@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"../prometheus"
+)
+
+// serviceTargetHealth summarizes the targets Prometheus scrapes for the jobs of a service.
+// Health is up when all targets are up, down when none is, degraded when some are,
+// and unknown when Prometheus has no scraped targets for the service.
+type serviceTargetHealth struct {
+	Service        string         `json:"service"`
+	Health         string         `json:"health"`
+	Targets        int            `json:"targets"`
+	Up             int            `json:"up"`
+	Down           int            `json:"down"`
+	LastError      string         `json:"lastError,omitempty"`
+	ScrapeDuration float64        `json:"scrapeDuration"`
+	FailingNodes   []string       `json:"failingNodes"`
+	Details        []targetHealth `json:"details"`
+}
+
+// targetHealth is the state of the last scrape of a target
+type targetHealth struct {
+	Job            string    `json:"job"`
+	Instance       string    `json:"instance"`
+	Node           string    `json:"node,omitempty"`
+	ScrapeURL      string    `json:"scrapeUrl"`
+	Health         string    `json:"health"`
+	LastError      string    `json:"lastError,omitempty"`
+	LastScrape     time.Time `json:"lastScrape"`
+	ScrapeDuration float64   `json:"scrapeDuration"`
+}
+
+type targetHealthResponse struct {
+	Status   int
+	Message  string
+	Services []serviceTargetHealth
+}
+
+// TargetHealthHandler joins the registered scrapes with the targets API of Prometheus
+// and reports the health of the targets of each service.
+// The serviceName query parameter limits the report to a service.
+func (s *serve) TargetHealthHandler(w http.ResponseWriter, req *http.Request) {
+	serviceName := req.URL.Query().Get("serviceName")
+	mu.Lock()
+	services := map[string]*serviceTargetHealth{}
+	jobs := map[string]*serviceTargetHealth{}
+	nodes := map[string]string{}
+	for _, sc := range s.scrapes {
+		if len(serviceName) > 0 && sc.ServiceName != serviceName {
+			continue
+		}
+		if _, ok := services[sc.ServiceName]; !ok {
+			services[sc.ServiceName] = &serviceTargetHealth{
+				Service:      sc.ServiceName,
+				FailingNodes: []string{},
+				Details:      []targetHealth{},
+			}
+		}
+		jobs[sc.JobName()] = services[sc.ServiceName]
+		for n := range sc.NodeInfo {
+			nodes[sc.JobName()+"/"+net.JoinHostPort(n.Addr, strconv.Itoa(sc.ScrapePort))] = n.Name
+		}
+	}
+	mu.Unlock()
+	if len(serviceName) > 0 && len(services) == 0 {
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("Service %s has no scrapes", serviceName))
+		return
+	}
+
+	targets, err := prometheus.NewAPIClient().Targets()
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].ScrapePool != targets[j].ScrapePool {
+			return targets[i].ScrapePool < targets[j].ScrapePool
+		}
+		return targets[i].Labels["instance"] < targets[j].Labels["instance"]
+	})
+	for _, t := range targets {
+		// The scrape pool is the name of the job. The job label can be changed by relabel configs.
+		job := t.ScrapePool
+		service, ok := jobs[job]
+		if !ok {
+			continue
+		}
+		node := t.Labels["node"]
+		if len(node) == 0 {
+			node = nodes[job+"/"+t.DiscoveredLabels["__address__"]]
+		}
+		service.Details = append(service.Details, targetHealth{
+			Job:            job,
+			Instance:       t.Labels["instance"],
+			Node:           node,
+			ScrapeURL:      t.ScrapeURL,
+			Health:         t.Health,
+			LastError:      t.LastError,
+			LastScrape:     t.LastScrape,
+			ScrapeDuration: t.LastScrapeDuration,
+		})
+	}
+
+	resp := []serviceTargetHealth{}
+	for _, service := range services {
+		service.summarize()
+		resp = append(resp, *service)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].Service < resp[j].Service
+	})
+	writeJSON(w, http.StatusOK, targetHealthResponse{Status: http.StatusOK, Message: "OK", Services: resp})
+}
+
+// summarize calculates the totals of the service from its target details
+func (h *serviceTargetHealth) summarize() {
+	h.Targets = len(h.Details)
+	failing := map[string]bool{}
+	var lastFailure time.Time
+	for _, t := range h.Details {
+		switch t.Health {
+		case "up":
+			h.Up++
+		case "down":
+			h.Down++
+			if len(t.Node) > 0 && !failing[t.Node] {
+				failing[t.Node] = true
+				h.FailingNodes = append(h.FailingNodes, t.Node)
+			}
+			if len(t.LastError) > 0 && !t.LastScrape.Before(lastFailure) {
+				lastFailure = t.LastScrape
+				h.LastError = t.LastError
+			}
+		}
+		if t.ScrapeDuration > h.ScrapeDuration {
+			h.ScrapeDuration = t.ScrapeDuration
+		}
+	}
+	sort.Strings(h.FailingNodes)
+	switch {
+	case h.Up+h.Down == 0:
+		h.Health = "unknown"
+	case h.Down == 0:
+		h.Health = "up"
+	case h.Up == 0:
+		h.Health = "down"
+	default:
+		h.Health = "degraded"
+	}
+}
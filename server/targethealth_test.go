@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"../prometheus"
+)
+
+// TargetHealthHandler
+
+func (s *ServerTestSuite) Test_TargetHealthHandler_ReturnsHealthOfServiceTargets() {
+	actualState := ""
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actualState = r.URL.Query().Get("state")
+		w.Write([]byte(`{"status":"success","data":{"activeTargets":[
+			{"discoveredLabels":{"__address__":"1.0.0.2:9404"},"scrapePool":"my-service_1","labels":{"job":"my-service_1","instance":"1.0.0.2:9404"},"scrapeUrl":"http://1.0.0.2:9404/metrics","lastError":"connection refused","lastScrape":"2021-03-01T10:00:05Z","lastScrapeDuration":0.002,"health":"down"},
+			{"discoveredLabels":{"__address__":"1.0.0.1:9404"},"scrapePool":"my-service_1","labels":{"job":"my-service_1","instance":"1.0.0.1:9404","node":"node-1"},"scrapeUrl":"http://1.0.0.1:9404/metrics","lastError":"","lastScrape":"2021-03-01T10:00:00Z","lastScrapeDuration":0.015,"health":"up"},
+			{"discoveredLabels":{"__address__":"1.0.0.3:9404"},"scrapePool":"my-service_1","labels":{"job":"my-service_1","instance":"1.0.0.3:9404","node":"node-3"},"scrapeUrl":"http://1.0.0.3:9404/metrics","lastError":"text format parsing error","lastScrape":"2021-03-01T10:00:01Z","lastScrapeDuration":0.02,"health":"down"},
+			{"discoveredLabels":{"__address__":"down-service:8080"},"scrapePool":"down-service","labels":{"job":"down-service","instance":"down-service:8080"},"lastError":"no such host","health":"down"},
+			{"discoveredLabels":{"__address__":"localhost:9090"},"scrapePool":"prometheus","labels":{"job":"prometheus","instance":"localhost:9090"},"health":"up"}]}}`))
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	nodeInfo := prometheus.NodeIPSet{}
+	nodeInfo.Add("node-1", "1.0.0.1", "id1")
+	nodeInfo.Add("node-2", "1.0.0.2", "id2")
+	nodeInfo.Add("node-3", "1.0.0.3", "id3")
+	serve.scrapes["my-service_1"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 9404, NodeInfo: nodeInfo, ScrapeIndex: 1}
+	serve.scrapes["down-service"] = prometheus.Scrape{ServiceName: "down-service", ScrapePort: 8080}
+	serve.scrapes["new-service"] = prometheus.Scrape{ServiceName: "new-service", ScrapePort: 8080}
+	actual := targetHealthResponse{}
+
+	status := s.serveJSON(serve.TargetHealthHandler, "/v1/docker-flow-monitor/targets/health", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Equal("active", actualState)
+	s.Require().Len(actual.Services, 3)
+	down, mine, unknown := actual.Services[0], actual.Services[1], actual.Services[2]
+	s.Equal("down-service", down.Service)
+	s.Equal("down", down.Health)
+	s.Equal("no such host", down.LastError)
+	s.Empty(down.FailingNodes)
+	s.Equal("my-service", mine.Service)
+	s.Equal("degraded", mine.Health)
+	s.Equal(3, mine.Targets)
+	s.Equal(1, mine.Up)
+	s.Equal(2, mine.Down)
+	s.Equal("connection refused", mine.LastError)
+	s.Equal(0.02, mine.ScrapeDuration)
+	s.Equal([]string{"node-2", "node-3"}, mine.FailingNodes)
+	s.Require().Len(mine.Details, 3)
+	s.Equal("node-1", mine.Details[0].Node)
+	s.Equal("node-2", mine.Details[1].Node)
+	s.Equal("my-service_1", mine.Details[1].Job)
+	s.Equal("new-service", unknown.Service)
+	s.Equal("unknown", unknown.Health)
+	s.Equal(0, unknown.Targets)
+}
+
+func (s *ServerTestSuite) Test_TargetHealthHandler_JoinsTargetsOnScrapePool_WhenJobLabelIsRelabelled() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"activeTargets":[
+			{"discoveredLabels":{"__address__":"my-service:8080"},"scrapePool":"my-service","labels":{"job":"payments","instance":"my-service:8080"},"health":"up"},
+			{"discoveredLabels":{"__address__":"other:8080"},"scrapePool":"other","labels":{"job":"my-service","instance":"other:8080"},"health":"down"}]}}`))
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=8080&relabel.1=targetLabel=job,replacement=payments")
+	s.Require().Len(serve.scrapes["my-service"].RelabelConfigs, 1)
+	actual := targetHealthResponse{}
+
+	status := s.serveJSON(serve.TargetHealthHandler, "/v1/docker-flow-monitor/targets/health?serviceName=my-service", &actual)
+
+	s.Equal(http.StatusOK, status)
+	s.Require().Len(actual.Services, 1)
+	s.Equal("up", actual.Services[0].Health)
+	s.Require().Len(actual.Services[0].Details, 1)
+	s.Equal("my-service", actual.Services[0].Details[0].Job)
+	s.Equal("my-service:8080", actual.Services[0].Details[0].Instance)
+}
+
+func (s *ServerTestSuite) Test_TargetHealthHandler_ReturnsNotFound_WhenServiceHasNoScrapes() {
+	serve := New()
+	serve.scrapes["my-service"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 8080}
+	actual := targetHealthResponse{}
+
+	status := s.serveJSON(serve.TargetHealthHandler, "/v1/docker-flow-monitor/targets/health?serviceName=other-service", &actual)
+
+	s.Equal(http.StatusNotFound, status)
+	s.Equal("Service other-service has no scrapes", actual.Message)
+}
+
+func (s *ServerTestSuite) Test_TargetHealthHandler_ReturnsError_WhenPrometheusFails() {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+	defer os.Unsetenv("DF_PROMETHEUS_URL")
+	os.Setenv("DF_PROMETHEUS_URL", testServer.URL)
+	serve := New()
+	serve.scrapes["my-service"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 8080}
+	actual := targetHealthResponse{}
+
+	status := s.serveJSON(serve.TargetHealthHandler, "/v1/docker-flow-monitor/targets/health?serviceName=my-service", &actual)
+
+	s.Equal(http.StatusInternalServerError, status)
+	s.Equal("prometheus responded to /api/v1/targets with 503: ", actual.Message)
+}
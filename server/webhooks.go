@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"../logging"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var webhooksPath = "/etc/dfm/webhooks.yaml"
+var webhookTimeout = 10 * time.Second
+var webhookBackoff = time.Second
+
+const webhookSignatureHeader = "X-DFM-Signature"
+
+// Webhook defines an external target notified when the monitoring configuration changes
+type Webhook struct {
+	Name        string            `yaml:"name"`
+	URL         string            `yaml:"url"`
+	Events      []string          `yaml:"events"`
+	Services    []string          `yaml:"services"`
+	Template    string            `yaml:"template"`
+	ContentType string            `yaml:"contentType"`
+	Headers     map[string]string `yaml:"headers"`
+	Secret      string            `yaml:"secret"`
+	SecretFile  string            `yaml:"secretFile"`
+	Retries     int               `yaml:"retries"`
+	template    *template.Template
+}
+
+// webhookPayload is sent to a webhook once per request that changed the configuration.
+// Events contains only the events that match the filters of the webhook.
+type webhookPayload struct {
+	Webhook     string  `json:"webhook"`
+	Events      []event `json:"events"`
+	Reload      string  `json:"reload"`
+	ReloadError string  `json:"reloadError,omitempty"`
+}
+
+type webhookSender struct {
+	webhooks []*Webhook
+	client   *http.Client
+	wg       sync.WaitGroup
+}
+
+// newWebhookSender reads webhooks from the file defined through DF_WEBHOOKS_CONFIG
+// or from /etc/dfm/webhooks.yaml
+func newWebhookSender() *webhookSender {
+	sender := &webhookSender{client: &http.Client{Timeout: webhookTimeout}}
+	configPath := webhooksPath
+	if len(os.Getenv("DF_WEBHOOKS_CONFIG")) > 0 {
+		configPath = os.Getenv("DF_WEBHOOKS_CONFIG")
+	}
+	data, err := afero.ReadFile(FS, configPath)
+	if err != nil {
+		logger.Debug("No webhooks are configured", logging.Fields{"path": configPath})
+		return sender
+	}
+	webhooks := []*Webhook{}
+	if err := yaml.Unmarshal(data, &webhooks); err != nil {
+		logger.Error("Unable to decode webhooks", logging.Fields{"path": configPath, "error": err})
+		return sender
+	}
+	for _, w := range webhooks {
+		if err := w.init(); err != nil {
+			logger.Error("Skipping webhook", logging.Fields{"webhook": w.Name, "error": err})
+			continue
+		}
+		sender.webhooks = append(sender.webhooks, w)
+	}
+	return sender
+}
+
+func (w *Webhook) init() error {
+	if len(w.URL) == 0 {
+		return fmt.Errorf("url is not defined")
+	}
+	if len(w.Name) == 0 {
+		w.Name = w.URL
+	}
+	for _, pattern := range w.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("event filter %s is not valid", pattern)
+		}
+	}
+	if len(w.SecretFile) > 0 {
+		secret, err := afero.ReadFile(FS, w.SecretFile)
+		if err != nil {
+			return err
+		}
+		w.Secret = strings.TrimSpace(string(secret))
+	}
+	if len(w.Template) > 0 {
+		t, err := template.New(w.Name).Parse(w.Template)
+		if err != nil {
+			return err
+		}
+		w.template = t
+	}
+	if len(w.ContentType) == 0 {
+		w.ContentType = "application/json"
+	}
+	return nil
+}
+
+// matches returns true when e passes the event and service filters.
+// Reload events are not tied to a service and pass the service filter.
+func (w *Webhook) matches(e event) bool {
+	if len(w.Events) > 0 {
+		matched := false
+		for _, pattern := range w.Events {
+			if ok, _ := path.Match(pattern, e.Type); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(w.Services) > 0 && len(e.Service) > 0 {
+		for _, service := range w.Services {
+			if service == e.Service {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (w *Webhook) body(payload webhookPayload) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(payload)
+	}
+	b := &bytes.Buffer{}
+	if err := w.template.Execute(b, payload); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// notify sends events to all matching webhooks without waiting for the deliveries.
+// Nothing is sent to a webhook when only the reload result matches its filters.
+func (ws *webhookSender) notify(events []event, reloadErr error) {
+	for _, w := range ws.webhooks {
+		payload := webhookPayload{Webhook: w.Name, Events: []event{}, Reload: "succeeded"}
+		if reloadErr != nil {
+			payload.Reload = "failed"
+			payload.ReloadError = reloadErr.Error()
+		}
+		changed := false
+		for _, e := range events {
+			if !w.matches(e) {
+				continue
+			}
+			payload.Events = append(payload.Events, e)
+			if !strings.HasPrefix(e.Type, "reload-") {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		body, err := w.body(payload)
+		if err != nil {
+			logger.Error("Unable to render webhook payload", logging.Fields{"webhook": w.Name, "error": err})
+			continue
+		}
+		ws.wg.Add(1)
+		go func(w *Webhook, body []byte) {
+			defer ws.wg.Done()
+			ws.deliver(w, body)
+		}(w, body)
+	}
+}
+
+// deliver posts body to the webhook, retrying with an exponential backoff
+func (ws *webhookSender) deliver(w *Webhook, body []byte) {
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := ws.post(w, body)
+		if err == nil {
+			logger.Debug("Webhook delivered", logging.Fields{"webhook": w.Name, "attempt": attempt + 1})
+			return
+		}
+		if attempt >= w.Retries {
+			logger.Error("Webhook delivery failed", logging.Fields{"webhook": w.Name, "attempts": attempt + 1, "error": err})
+			return
+		}
+		logger.Warn("Retrying webhook delivery", logging.Fields{"webhook": w.Name, "attempt": attempt + 1, "backoff": backoff.String(), "error": err})
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (ws *webhookSender) post(w *Webhook, body []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.ContentType)
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	if len(w.Secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signPayload(w.Secret, body))
+	}
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with the status code %d", w.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of body
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../prometheus"
+	"github.com/spf13/afero"
+)
+
+// Webhooks
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_NotifiesWebhooks() {
+	bodies, headers, testServer := s.webhookServer(http.StatusOK)
+	defer testServer.Close()
+	s.writeWebhooks(`
+- name: chat
+  url: ` + testServer.URL + `
+  events: ["scrape-*", "alert-added", "reload-*"]
+  secret: my-secret
+`)
+
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234&alertName=my-alert&alertIf=my-if")
+	serve.webhooks.wg.Wait()
+
+	s.Require().Len(*bodies, 1)
+	payload := webhookPayload{}
+	s.Require().NoError(json.Unmarshal([]byte((*bodies)[0]), &payload))
+	s.Equal("chat", payload.Webhook)
+	s.Equal("succeeded", payload.Reload)
+	s.Require().Len(payload.Events, 3)
+	s.Equal("scrape-added", payload.Events[0].Type)
+	s.Equal("alert-added", payload.Events[1].Type)
+	s.Equal("reload-succeeded", payload.Events[2].Type)
+	s.Equal("application/json", (*headers)[0].Get("Content-Type"))
+	s.Equal("sha256="+signPayload("my-secret", []byte((*bodies)[0])), (*headers)[0].Get(webhookSignatureHeader))
+}
+
+func (s *ServerTestSuite) Test_ReconfigureHandler_RendersWebhookTemplate() {
+	prometheus.Reload = func() error {
+		return errors.New("Prometheus error")
+	}
+	bodies, headers, testServer := s.webhookServer(http.StatusOK)
+	defer testServer.Close()
+	s.writeWebhooks(`
+- url: ` + testServer.URL + `
+  services: [my-service]
+  contentType: text/plain
+  headers:
+    X-Token: my-token
+  template: '{{range .Events}}{{.Type}} {{.Service}};{{end}} reload {{.Reload}}: {{.ReloadError}}'
+`)
+
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=other-service&scrapePort=1234")
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	serve.webhooks.wg.Wait()
+
+	s.Equal([]string{"scrape-added my-service;reload-failed ; reload failed: Prometheus error"}, *bodies)
+	s.Equal("text/plain", (*headers)[0].Get("Content-Type"))
+	s.Equal("my-token", (*headers)[0].Get("X-Token"))
+	s.Empty((*headers)[0].Get(webhookSignatureHeader))
+}
+
+func (s *ServerTestSuite) Test_RemoveHandler_DoesNotNotifyWebhooks_WhenNothingMatches() {
+	bodies, _, testServer := s.webhookServer(http.StatusOK)
+	defer testServer.Close()
+	s.writeWebhooks(`
+- url: ` + testServer.URL + `
+  events: ["node-*", "reload-*"]
+`)
+
+	serve := New()
+	serve.scrapes["my-service"] = prometheus.Scrape{ServiceName: "my-service", ScrapePort: 1234}
+	req, _ := http.NewRequest("DELETE", "/v1/docker-flow-monitor/remove?serviceName=my-service", nil)
+	serve.RemoveHandler(ResponseWriterMock{}, req)
+	serve.webhooks.wg.Wait()
+
+	s.Empty(*bodies)
+}
+
+func (s *ServerTestSuite) Test_Webhooks_RetryWithBackoff() {
+	backoffOrig := webhookBackoff
+	defer func() { webhookBackoff = backoffOrig }()
+	webhookBackoff = time.Millisecond
+	recovering := int32(0)
+	recoveringServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&recovering, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer recoveringServer.Close()
+	failing := int32(0)
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failing, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failingServer.Close()
+	s.writeWebhooks(`
+- url: ` + recoveringServer.URL + `
+  retries: 5
+- url: ` + failingServer.URL + `
+  retries: 1
+`)
+
+	serve := New()
+	s.reconfigure(serve, "/v1/docker-flow-monitor/reconfigure?serviceName=my-service&scrapePort=1234")
+	serve.webhooks.wg.Wait()
+
+	s.Equal(int32(3), recovering)
+	s.Equal(int32(2), failing)
+}
+
+func (s *ServerTestSuite) Test_Webhooks_ReadsSecretFile() {
+	afero.WriteFile(FS, "/run/secrets/webhook-secret", []byte("my-secret\n"), 0644)
+	defer FS.Remove("/run/secrets/webhook-secret")
+	s.writeWebhooks(`
+- url: http://my-bot
+  secretFile: /run/secrets/webhook-secret
+- url: http://other-bot
+  secretFile: /run/secrets/does-not-exist
+- name: no-url
+`)
+
+	sender := newWebhookSender()
+
+	s.Require().Len(sender.webhooks, 1)
+	s.Equal("http://my-bot", sender.webhooks[0].Name)
+	s.Equal("my-secret", sender.webhooks[0].Secret)
+}
+
+// Util
+
+func (s *ServerTestSuite) webhookServer(status int) (*[]string, *[]http.Header, *httptest.Server) {
+	m := &sync.Mutex{}
+	bodies := []string{}
+	headers := []http.Header{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		headers = append(headers, r.Header)
+		w.WriteHeader(status)
+	}))
+	return &bodies, &headers, testServer
+}
+
+func (s *ServerTestSuite) writeWebhooks(config string) {
+	afero.WriteFile(FS, "/etc/dfm/test-webhooks.yaml", []byte(config), 0644)
+	os.Setenv("DF_WEBHOOKS_CONFIG", "/etc/dfm/test-webhooks.yaml")
+	s.T().Cleanup(func() {
+		os.Unsetenv("DF_WEBHOOKS_CONFIG")
+		FS.Remove("/etc/dfm/test-webhooks.yaml")
+	})
+}